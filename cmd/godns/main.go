@@ -23,15 +23,6 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// 判断 StcpKey 是否设置
-	log.Printf("StcpKey: %v", len(opts.StcpKey))
-	if len(opts.StcpKey) == 0 {
-		stcpKeyPath := filepath.Join(filepath.Dir(*configPath), "stcp.key")
-		slog.Info("generate stcp key", "path", stcpKeyPath)
-		if stcpKey, err := key.Generate(stcpKeyPath); err == nil {
-			opts.StcpKey = stcpKey.String()
-		}
-	}
 	privateKey, err := key.Base64(opts.StcpKey)
 	if err != nil {
 		slog.Error("parse stcp key error", "err", err)
@@ -45,11 +36,18 @@ func main() {
 	slog.Info("STCP", "publicKey", publicKey.String())
 
 	server := godns.NewDnsServer(opts, nil)
+	server.SetLoader(func() (*godns.Options, error) {
+		return loadConfig(*configPath)
+	})
 	errCh := make(chan error, 1)
 	go func() {
 		errCh <- server.Serve()
 	}()
 
+	// SIGHUP 热重载配置
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
 	// 优雅退出
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit,
@@ -58,10 +56,19 @@ func main() {
 		syscall.SIGINT,  // kill -2
 		syscall.SIGQUIT, // kill -3
 	)
-	select {
-	case err = <-errCh:
-		slog.Error("dns server serve error", "err", err)
-	case <-quit:
+loop:
+	for {
+		select {
+		case err = <-errCh:
+			slog.Error("dns server serve error", "err", err)
+			break loop
+		case <-hup:
+			if err := server.ReloadConfig(); err != nil {
+				slog.Error("reload config failed, keep running with old config", "err", err)
+			}
+		case <-quit:
+			break loop
+		}
 	}
 
 	log.Println("Shutting down server...")
@@ -83,5 +90,14 @@ func loadConfig(name string) (*godns.Options, error) {
 	if err = yaml.Unmarshal(data, &opts); err != nil {
 		return nil, err
 	}
+
+	// 判断 StcpKey 是否设置
+	if len(opts.StcpKey) == 0 {
+		stcpKeyPath := filepath.Join(filepath.Dir(name), "stcp.key")
+		slog.Info("generate stcp key", "path", stcpKeyPath)
+		if stcpKey, err := key.Generate(stcpKeyPath); err == nil {
+			opts.StcpKey = stcpKey.String()
+		}
+	}
 	return &opts, nil
 }
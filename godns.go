@@ -1,7 +1,6 @@
 package godns
 
 import (
-	"context"
 	"log/slog"
 	gohttp "net/http"
 	_ "net/http/pprof"
@@ -9,14 +8,14 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/taodev/godns/internal/admin"
 	"github.com/taodev/godns/internal/cache"
+	"github.com/taodev/godns/internal/filter"
+	"github.com/taodev/godns/internal/querylog"
 	"github.com/taodev/godns/internal/rewrite"
 	"github.com/taodev/godns/internal/route"
 	"github.com/taodev/godns/internal/transport"
-	"github.com/taodev/godns/internal/transport/http"
-	"github.com/taodev/godns/internal/transport/tcp"
-	"github.com/taodev/godns/internal/transport/udp"
-	"github.com/taodev/godns/internal/utils"
+	"github.com/taodev/godns/internal/zone"
 	"github.com/taodev/godns/pkg/bootstrap"
 	"github.com/taodev/pkg/geodb"
 )
@@ -24,18 +23,24 @@ import (
 type DnsServer struct {
 	Options *Options
 	logger  *slog.Logger
+	level   slog.LevelVar
+	loader  func() (*Options, error)
 
-	inboundUDP   *udp.Inbound
-	inboundTCP   *tcp.Inbound
-	inboundTLS   *tcp.Inbound
-	inboundSTCP  *tcp.Inbound
-	inboundHTTP  *http.Inbound
-	inboundHTTPS *http.Inbound
+	// 入站，key 为入站类型
+	inbounds map[string]inbound
 
+	// access 保护 Options、outbound、rewriter 与 filter，热重载时整体替换
+	access   sync.RWMutex
 	outbound *transport.Manager
-	router   *route.Router
+	router   *routerHolder
 	rewriter *rewrite.Rewriter
+	filter   *filter.Filter
 	cache    *cache.Cache
+	querylog *querylog.QueryLog
+	admin    *admin.Server
+
+	// 串行化热重载与关闭
+	reloadMu sync.Mutex
 
 	closeCh   chan struct{}
 	closeOnce sync.Once
@@ -48,9 +53,10 @@ type DnsServer struct {
 
 func (s *DnsServer) init() (err error) {
 	opts := s.Options
+	s.level.Set(opts.LoggerLevel())
 	if s.logger == nil {
 		s.logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-			Level: opts.LoggerLevel(),
+			Level: &s.level,
 			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
 				if a.Key == slog.TimeKey {
 					// 自定义时间格式：2006-01-02 15:04:05
@@ -73,6 +79,7 @@ func (s *DnsServer) init() (err error) {
 	}
 
 	geodb.GeoSitePath = opts.GeoSite
+	route.GeoIPPath = opts.GeoIP
 
 	// 初始化 bootstrap dns
 	if err := bootstrap.SetDNS(opts.BootstrapDNS); err != nil {
@@ -84,14 +91,35 @@ func (s *DnsServer) init() (err error) {
 	if s.cache, err = cache.New(&opts.Cache); err != nil {
 		return err
 	}
-	s.outbound = transport.NewManager(opts.Outbounds, opts.StcpKey)
+	if s.outbound, err = transport.NewManager(opts.Outbounds, opts.OutboundGroups, &opts.HealthCheck, opts.StcpKey); err != nil {
+		return err
+	}
 	s.rewriter, err = rewrite.NewRewriter(opts.Rewrite)
 	if err != nil {
 		return err
 	}
-	if s.router, err = route.New(&opts.Route, s.outbound, s.rewriter, s.cache); err != nil {
+	if s.querylog, err = querylog.New(&opts.QueryLog); err != nil {
+		return err
+	}
+	if err = s.querylog.Start(); err != nil {
+		return err
+	}
+	if s.filter, err = filter.New(opts.Filter); err != nil {
+		return err
+	}
+	if err = s.filter.Start(); err != nil {
+		return err
+	}
+	zones, err := zone.New(opts.Zones)
+	if err != nil {
 		return err
 	}
+	router, err := route.New(&opts.Route, s.outbound, s.rewriter, s.filter, zones, s.cache, s.querylog)
+	if err != nil {
+		return err
+	}
+	s.router = newRouterHolder(router)
+	s.cache.SetQuery(s.router)
 
 	s.closeCh = make(chan struct{})
 	s.errorCh = make(chan error)
@@ -100,49 +128,17 @@ func (s *DnsServer) init() (err error) {
 		return err
 	}
 
-	// new version
-	if opts.Inbounds.UDP != nil {
-		opts.Inbounds.UDP.Type = utils.TypeUDP
-		s.inboundUDP = udp.NewInbound(context.Background(), s.router, opts.Inbounds.UDP)
-		if err = s.inboundUDP.Start(); err != nil {
-			return err
-		}
-	}
-	if opts.Inbounds.TCP != nil {
-		opts.Inbounds.TCP.Type = utils.TypeTCP
-		s.inboundTCP = tcp.NewInbound(context.Background(), s.router, opts.Inbounds.TCP)
-		if err = s.inboundTCP.Start(); err != nil {
+	opts.prepareInbounds()
+	s.inbounds = make(map[string]inbound)
+	for typ, inboundOpts := range opts.inboundOptions() {
+		if err = s.startInbound(typ, inboundOpts); err != nil {
 			return err
 		}
 	}
-	if opts.Inbounds.TLS != nil {
-		opts.Inbounds.TLS.Type = utils.TypeTLS
-		s.inboundTLS = tcp.NewInbound(context.Background(), s.router, opts.Inbounds.TLS)
-		if err = s.inboundTLS.Start(); err != nil {
-			return err
-		}
-	}
-	if opts.Inbounds.STCP != nil {
-		opts.Inbounds.STCP.Type = utils.TypeSTCP
-		if len(opts.Inbounds.STCP.Key) == 0 {
-			opts.Inbounds.STCP.Key = opts.StcpKey
-		}
-		s.inboundSTCP = tcp.NewInbound(context.Background(), s.router, opts.Inbounds.STCP)
-		if err = s.inboundSTCP.Start(); err != nil {
-			return err
-		}
-	}
-	if opts.Inbounds.HTTP != nil {
-		opts.Inbounds.HTTP.Type = utils.TypeHTTP
-		s.inboundHTTP = http.NewInbound(context.Background(), s.router, opts.Inbounds.HTTP)
-		if err = s.inboundHTTP.Start(); err != nil {
-			return err
-		}
-	}
-	if opts.Inbounds.HTTPS != nil {
-		opts.Inbounds.HTTPS.Type = utils.TypeHTTPS
-		s.inboundHTTPS = http.NewInbound(context.Background(), s.router, opts.Inbounds.HTTPS)
-		if err = s.inboundHTTPS.Start(); err != nil {
+
+	if opts.Admin.Addr != "" {
+		s.admin = admin.New(&opts.Admin, s)
+		if err = s.admin.Start(); err != nil {
 			return err
 		}
 	}
@@ -164,31 +160,23 @@ func (s *DnsServer) Serve() (err error) {
 	case <-s.closeCh:
 	}
 
-	// new version
-	if s.inboundUDP != nil {
-		s.inboundUDP.Close()
-	}
-	if s.inboundTCP != nil {
-		s.inboundTCP.Close()
-	}
-	if s.inboundTLS != nil {
-		s.inboundTLS.Close()
-	}
-	if s.inboundSTCP != nil {
-		s.inboundSTCP.Close()
-	}
-	if s.inboundHTTP != nil {
-		s.inboundHTTP.Close()
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	for _, in := range s.inbounds {
+		in.Close()
 	}
-	if s.inboundHTTPS != nil {
-		s.inboundHTTPS.Close()
+	if s.admin != nil {
+		s.admin.Close()
 	}
 
 	s.cache.Close()
+	s.querylog.Close()
 
 	if s.outbound != nil {
 		s.outbound.Close()
 	}
+	s.filter.Close()
 
 	slog.Debug("dns server close")
 	return err
@@ -196,7 +184,10 @@ func (s *DnsServer) Serve() (err error) {
 
 func (s *DnsServer) close() error {
 	s.closeOnce.Do(func() {
-		close(s.closeCh)
+		// 初始化失败时 closeCh 尚未创建
+		if s.closeCh != nil {
+			close(s.closeCh)
+		}
 	})
 	return nil
 }
@@ -214,6 +205,30 @@ func (s *DnsServer) Shutdown() (err error) {
 	return
 }
 
+func (s *DnsServer) Outbound() *transport.Manager {
+	s.access.RLock()
+	defer s.access.RUnlock()
+	return s.outbound
+}
+
+func (s *DnsServer) Router() *route.Router {
+	return s.router.Load()
+}
+
+func (s *DnsServer) Rewriter() *rewrite.Rewriter {
+	s.access.RLock()
+	defer s.access.RUnlock()
+	return s.rewriter
+}
+
+func (s *DnsServer) Cache() *cache.Cache {
+	return s.cache
+}
+
+func (s *DnsServer) QueryLog() *querylog.QueryLog {
+	return s.querylog
+}
+
 func NewDnsServer(opts *Options, logger *slog.Logger) *DnsServer {
 	return &DnsServer{
 		Options: opts,
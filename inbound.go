@@ -0,0 +1,134 @@
+package godns
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/taodev/godns/internal/admin"
+	"github.com/taodev/godns/internal/transport/http"
+	"github.com/taodev/godns/internal/transport/quic"
+	"github.com/taodev/godns/internal/transport/tcp"
+	"github.com/taodev/godns/internal/transport/udp"
+	"github.com/taodev/godns/internal/utils"
+)
+
+type inbound interface {
+	Start() error
+	Close() error
+}
+
+// prepareInbounds 补全入站类型与默认配置
+func (o *Options) prepareInbounds() {
+	inbounds := &o.Inbounds
+	if inbounds.UDP != nil {
+		inbounds.UDP.Type = utils.TypeUDP
+	}
+	if inbounds.TCP != nil {
+		inbounds.TCP.Type = utils.TypeTCP
+	}
+	if inbounds.TLS != nil {
+		inbounds.TLS.Type = utils.TypeTLS
+	}
+	if inbounds.STCP != nil {
+		inbounds.STCP.Type = utils.TypeSTCP
+		if len(inbounds.STCP.Key) == 0 {
+			inbounds.STCP.Key = o.StcpKey
+		}
+	}
+	if inbounds.HTTP != nil {
+		inbounds.HTTP.Type = utils.TypeHTTP
+	}
+	if inbounds.HTTPS != nil {
+		inbounds.HTTPS.Type = utils.TypeHTTPS
+	}
+	if inbounds.QUIC != nil {
+		inbounds.QUIC.Type = utils.TypeQUIC
+	}
+}
+
+// inboundOptions 已启用的入站配置，key 为入站类型
+func (o *Options) inboundOptions() map[string]any {
+	inbounds := o.Inbounds
+	opts := make(map[string]any)
+	if inbounds.UDP != nil {
+		opts[utils.TypeUDP] = inbounds.UDP
+	}
+	if inbounds.TCP != nil {
+		opts[utils.TypeTCP] = inbounds.TCP
+	}
+	if inbounds.TLS != nil {
+		opts[utils.TypeTLS] = inbounds.TLS
+	}
+	if inbounds.STCP != nil {
+		opts[utils.TypeSTCP] = inbounds.STCP
+	}
+	if inbounds.HTTP != nil {
+		opts[utils.TypeHTTP] = inbounds.HTTP
+	}
+	if inbounds.HTTPS != nil {
+		opts[utils.TypeHTTPS] = inbounds.HTTPS
+	}
+	if inbounds.QUIC != nil {
+		opts[utils.TypeQUIC] = inbounds.QUIC
+	}
+	return opts
+}
+
+func (s *DnsServer) startInbound(typ string, opts any) error {
+	in, err := s.newInbound(typ, opts)
+	if err != nil {
+		return err
+	}
+	s.inbounds[typ] = in
+	return nil
+}
+
+// newInbound 创建并启动入站
+func (s *DnsServer) newInbound(typ string, opts any) (in inbound, err error) {
+	switch opts := opts.(type) {
+	case *udp.Options:
+		in = udp.NewInbound(context.Background(), s.router, opts)
+	case *tcp.Options:
+		in = tcp.NewInbound(context.Background(), s.router, opts)
+	case *http.Options:
+		in = http.NewInbound(context.Background(), s.router, opts)
+	case *quic.Options:
+		in = quic.NewInbound(context.Background(), s.router, opts)
+	default:
+		return nil, fmt.Errorf("unknown inbound type: %s", typ)
+	}
+	if err = in.Start(); err != nil {
+		return nil, fmt.Errorf("inbound %s: %w", typ, err)
+	}
+	return in, nil
+}
+
+// Inbounds 已启用的入站
+func (s *DnsServer) Inbounds() (list []admin.InboundInfo) {
+	s.access.RLock()
+	defer s.access.RUnlock()
+	for typ, opts := range s.Options.inboundOptions() {
+		list = append(list, admin.InboundInfo{Type: typ, Addr: inboundAddr(opts)})
+	}
+	slices.SortFunc(list, func(a, b admin.InboundInfo) int {
+		return strings.Compare(a.Type, b.Type)
+	})
+	return list
+}
+
+// inboundAddr 入站的监听地址
+func inboundAddr(opts any) string {
+	switch opts := opts.(type) {
+	case *udp.Options:
+		return opts.Addr
+	case *tcp.Options:
+		return opts.Addr
+	case *http.Options:
+		return opts.Addr
+	case *quic.Options:
+		return opts.Addr
+	}
+	return ""
+}
@@ -22,6 +22,8 @@ type Outbound interface {
 
 type OutboundManager interface {
 	Get(tag string) (Outbound, bool)
+	// 上游是否健康（未熔断）
+	Healthy(tag string) bool
 	// Exchange(req *dns.Msg) (*dns.Msg, time.Duration, error)
 }
 
@@ -30,5 +32,6 @@ type Router interface {
 }
 
 type DnsQuery interface {
-	Resolve(in *dns.Msg, ip net.IP) (resp *dns.Msg, outboundTag string, err error)
+	// scope 为缓存隔离域，为空时使用默认策略；查询结果写入缓存
+	Resolve(in *dns.Msg, scope string, ip net.IP) (resp *dns.Msg, outboundTag string, err error)
 }
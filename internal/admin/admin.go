@@ -0,0 +1,347 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/taodev/godns/internal/cache"
+	"github.com/taodev/godns/internal/metrics"
+	"github.com/taodev/godns/internal/querylog"
+	"github.com/taodev/godns/internal/rewrite"
+	"github.com/taodev/godns/internal/route"
+	"github.com/taodev/godns/internal/transport"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	// 查询日志默认返回条数
+	defaultQueryLogLimit = 100
+)
+
+// 管理接口配置
+type Options struct {
+	// 监听地址，为空时不启用
+	Addr string `yaml:"addr"`
+	// Bearer Token，为空时不鉴权
+	Token string `yaml:"token"`
+}
+
+// InboundInfo 入站信息
+type InboundInfo struct {
+	Type string `json:"type"`
+	Addr string `json:"addr"`
+}
+
+// Provider 提供运行中的各组件，由 DnsServer 实现
+type Provider interface {
+	Inbounds() []InboundInfo
+	Outbound() *transport.Manager
+	Router() *route.Router
+	Rewriter() *rewrite.Rewriter
+	Cache() *cache.Cache
+	QueryLog() *querylog.QueryLog
+	// 重新加载配置文件
+	ReloadConfig() error
+}
+
+type Server struct {
+	options    *Options
+	provider   Provider
+	mux        *http.ServeMux
+	listener   net.Listener
+	httpServer *http.Server
+	wait       sync.WaitGroup
+}
+
+func New(options *Options, provider Provider) *Server {
+	s := &Server{
+		options:  options,
+		provider: provider,
+		mux:      http.NewServeMux(),
+	}
+	s.HandleFunc("GET /api/inbounds", s.handleInbounds)
+	s.HandleFunc("GET /api/outbounds", s.handleOutbounds)
+	s.HandleFunc("GET /api/route/rules", s.handleRouteRules)
+	s.HandleFunc("GET /api/route", s.handleRoute)
+	s.HandleFunc("GET /api/cache", s.handleCacheGet)
+	s.HandleFunc("DELETE /api/cache", s.handleCacheDelete)
+	s.HandleFunc("GET /api/cache/stats", s.handleCacheStats)
+	s.HandleFunc("GET /api/rewrite/rules", s.handleRewriteRules)
+	s.HandleFunc("GET /api/filter/lists", s.handleFilterLists)
+	s.HandleFunc("GET /api/zones", s.handleZones)
+	s.HandleFunc("GET /api/querylog", s.handleQueryLog)
+	s.HandleFunc("POST /api/reload", s.handleReload)
+	s.HandleFunc("GET /metrics", metrics.Handler().ServeHTTP)
+	s.httpServer = &http.Server{
+		Addr:              options.Addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: defaultTimeout,
+		WriteTimeout:      defaultTimeout,
+	}
+	return s
+}
+
+// HandleFunc 注册管理接口，统一经过鉴权
+func (s *Server) HandleFunc(pattern string, handler http.HandlerFunc) {
+	s.mux.Handle(pattern, s.auth(handler))
+}
+
+func (s *Server) Start() (err error) {
+	if s.listener, err = net.Listen("tcp", s.options.Addr); err != nil {
+		return err
+	}
+	s.wait.Add(1)
+	go func() {
+		defer s.wait.Done()
+		if err := s.httpServer.Serve(s.listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("admin server serve failed", "err", err)
+		}
+	}()
+	if s.options.Token == "" {
+		slog.Warn("[admin] token is empty, api is not protected")
+	}
+	slog.Info(fmt.Sprintf("[admin] %s started", s.options.Addr))
+	return nil
+}
+
+func (s *Server) Close() (err error) {
+	if err = s.httpServer.Shutdown(context.Background()); err != nil {
+		slog.Error("admin server shutdown failed", "err", err)
+	}
+	s.wait.Wait()
+	return err
+}
+
+// auth 校验 Bearer Token
+func (s *Server) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.options.Token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.options.Token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleInbounds(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.provider.Inbounds())
+}
+
+func (s *Server) handleOutbounds(w http.ResponseWriter, r *http.Request) {
+	outbound := s.provider.Outbound()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"outbounds": outbound.List(),
+		"health":    outbound.Health(),
+	})
+}
+
+func (s *Server) handleRouteRules(w http.ResponseWriter, r *http.Request) {
+	router := s.provider.Router()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"rules":   router.Rules(),
+		"default": router.Default(),
+	})
+}
+
+// handleRoute 查询域名命中的上游，可通过 client、inbound 指定客户端 IP 与入站类型，按命中的客户端策略判断
+func (s *Server) handleRoute(w http.ResponseWriter, r *http.Request) {
+	domain, qtype, ok := parseQuestion(w, r)
+	if !ok {
+		return
+	}
+	var client netip.Addr
+	if v := r.URL.Query().Get("client"); v != "" {
+		var err error
+		if client, err = netip.ParseAddr(v); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid client")
+			return
+		}
+	}
+	policy, outbound := s.provider.Router().Explain(domain, qtype, client, r.URL.Query().Get("inbound"))
+	result := map[string]any{
+		"domain": domain,
+		"qtype":  dns.TypeToString[qtype],
+	}
+	if policy != "" {
+		result["policy"] = policy
+	}
+	if outbound != "" {
+		result["outbound"] = outbound
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleCacheGet(w http.ResponseWriter, r *http.Request) {
+	key, ok := parseCacheKey(w, r)
+	if !ok {
+		return
+	}
+	cv, ok := s.provider.Cache().Get(key)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	msg := cv.Msg()
+	answers := make([]string, 0, len(msg.Answer))
+	for _, rr := range msg.Answer {
+		answers = append(answers, rr.String())
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"domain":    key.Name,
+		"qtype":     dns.TypeToString[key.Qtype],
+		"key":       key.String(),
+		"rcode":     dns.RcodeToString[cv.M.Rcode],
+		"answer":    answers,
+		"client":    cv.Addr,
+		"stored_at": time.Unix(cv.StoredAt, 0),
+		"expire_at": time.Unix(cv.ExpireAt, 0),
+		"expired":   cv.IsExpired(),
+		"hits":      cv.Hits(),
+	})
+}
+
+// handleCacheDelete 不带 domain 时清空缓存，否则删除该域名（及 qtype）的所有条目，不区分隔离域、DO/CD 标志与 ECS 子网
+func (s *Server) handleCacheDelete(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("domain") == "" {
+		s.provider.Cache().Clear()
+		writeJSON(w, http.StatusOK, map[string]any{"flushed": true})
+		return
+	}
+	domain, qtype, ok := parseQuestion(w, r)
+	if !ok {
+		return
+	}
+	if query.Get("qtype") == "" {
+		qtype = 0
+	}
+	result := map[string]any{
+		"domain":  dns.CanonicalName(domain),
+		"deleted": s.provider.Cache().Purge(domain, qtype),
+	}
+	if qtype != 0 {
+		result["qtype"] = dns.TypeToString[qtype]
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.provider.Cache().Stats())
+}
+
+func (s *Server) handleRewriteRules(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.provider.Rewriter().Rules())
+}
+
+func (s *Server) handleFilterLists(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.provider.Router().Filter().Lists())
+}
+
+func (s *Server) handleZones(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.provider.Router().Zones().List())
+}
+
+// handleQueryLog 最近的查询日志，可按 client、domain 过滤
+func (s *Server) handleQueryLog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultQueryLogLimit
+	if v := query.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+	domain := query.Get("domain")
+	if domain != "" {
+		domain = dns.Fqdn(domain)
+	}
+	entries := s.provider.QueryLog().Recent(query.Get("client"), domain, limit)
+	if entries == nil {
+		entries = []*querylog.Entry{}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// handleReload 重新加载配置，失败时旧配置继续运行
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if err := s.provider.ReloadConfig(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"reloaded": true})
+}
+
+// parseQuestion 解析 domain 与 qtype（默认 A）查询参数
+func parseQuestion(w http.ResponseWriter, r *http.Request) (domain string, qtype uint16, ok bool) {
+	query := r.URL.Query()
+	domain = query.Get("domain")
+	if domain == "" {
+		writeError(w, http.StatusBadRequest, "domain is required")
+		return "", 0, false
+	}
+	if _, valid := dns.IsDomainName(domain); !valid {
+		writeError(w, http.StatusBadRequest, "invalid domain")
+		return "", 0, false
+	}
+	qtype = dns.TypeA
+	if t := query.Get("qtype"); t != "" {
+		if qtype, ok = dns.StringToType[strings.ToUpper(t)]; !ok {
+			writeError(w, http.StatusBadRequest, "invalid qtype")
+			return "", 0, false
+		}
+	}
+	return dns.Fqdn(domain), qtype, true
+}
+
+// parseCacheKey 解析缓存键，do、cd 为请求标志，scope 为缓存隔离域，subnet 为 ECS 子网
+func parseCacheKey(w http.ResponseWriter, r *http.Request) (key cache.Key, ok bool) {
+	domain, qtype, ok := parseQuestion(w, r)
+	if !ok {
+		return key, false
+	}
+	query := r.URL.Query()
+	req := new(dns.Msg)
+	req.SetQuestion(domain, qtype)
+	req.CheckingDisabled = query.Get("cd") == "true"
+	if query.Get("do") == "true" {
+		req.SetEdns0(dns.DefaultMsgSize, true)
+	}
+	key = cache.NewKey(req, query.Get("scope"))
+	if v := query.Get("subnet"); v != "" {
+		subnet, err := netip.ParsePrefix(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid subnet")
+			return key, false
+		}
+		key.Subnet = netip.PrefixFrom(subnet.Addr().Unmap(), subnet.Bits()).Masked()
+	}
+	return key, true
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("admin write response failed", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
@@ -1,79 +1,259 @@
 package cache
 
 import (
-	"fmt"
 	"log/slog"
+	"math"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgraph-io/ristretto/v2"
 	"github.com/miekg/dns"
 	"github.com/taodev/godns/internal/adapter"
+	"github.com/taodev/godns/internal/metrics"
 	"github.com/taodev/godns/internal/utils"
 )
 
 type Options struct {
-	// 最大缓存条目数
-	MaxCounters int64 `yaml:"max-counters" default:"10000"`
-	// 最大缓存成本（与条目大小相关）
-	MaxCost int64 `yaml:"max-cost" default:"10000"`
+	// 统计访问频率的键数量，建议为预期条目数的 10 倍
+	MaxCounters int64 `yaml:"max-counters" default:"100000"`
+	// 缓存最大字节数，按打包后的应答大小计算，必须带单位（支持 512KB、64MB、1GB）
+	MaxCost utils.ByteSize `yaml:"max-cost" default:"64MB"`
 	// 写缓存数量
 	BufferItems int64 `yaml:"buffer-items" default:"64"`
-	// 缓存默认 TTL
-	TTL time.Duration `yaml:"ttl" default:"24h"`
+	// 最小缓存时长，应答 TTL 小于该值时按该值缓存
+	MinTTL time.Duration `yaml:"min-ttl" default:"0s"`
+	// 最大缓存时长
+	MaxTTL time.Duration `yaml:"max-ttl" default:"24h"`
+	// 否定应答（NXDOMAIN/NODATA）最大缓存时长
+	NegativeTTL time.Duration `yaml:"negative-ttl" default:"1h"`
 	// 缓存线程数
 	Threads int `yaml:"threads" default:"5"`
-	// 重新请求 TTL
-	RefreshTTL time.Duration `yaml:"refresh-ttl" default:"5m"`
+	// 缓存时长内命中次数达到该值的条目在过期前预取，为 0 时不预取
+	PrefetchHits int `yaml:"prefetch-hits" default:"2"`
+	// 剩余缓存时长低于该比例时预取
+	PrefetchRatio float64 `yaml:"prefetch-ratio" default:"0.1"`
+	// 预取队列长度，队列满时丢弃
+	PrefetchQueue int `yaml:"prefetch-queue" default:"256"`
+	// 过期应答最长保留时长，上游失败或超时时返回过期应答（RFC 8767），为 0 时不启用
+	MaxStale time.Duration `yaml:"max-stale" default:"0s"`
+	// 过期应答的 TTL
+	StaleTTL time.Duration `yaml:"stale-ttl" default:"30s"`
+	// 存在过期应答时等待上游的时长，超时后返回过期应答
+	StaleTimeout time.Duration `yaml:"stale-timeout" default:"1800ms"`
+	// 上游刷新失败后直接返回过期应答、不再查询上游的时长（RFC 8767 failure recheck timer），为 0 时不启用
+	StaleRecheck time.Duration `yaml:"stale-recheck" default:"30s"`
+	// 快照文件，关闭时保存、启动时加载，为空时不启用
+	Snapshot string `yaml:"snapshot"`
+	// 快照定时保存间隔，为 0 时仅在关闭时保存
+	SnapshotInterval time.Duration `yaml:"snapshot-interval" default:"10m"`
 }
 
 type CacheValue struct {
-	M        *dns.Msg
-	Addr     string
+	M    *dns.Msg
+	Addr string
+	// 写入时间
+	StoredAt int64
+	// 过期时间，由应答 TTL 决定
 	ExpireAt int64
+	// 预取时间，为 0 时不预取
+	PrefetchAt int64
+
+	key Key
+	// 写入后的命中次数
+	hits *atomic.Int64
 }
 
 // 是否过期
 func (cv CacheValue) IsExpired() bool {
-	return time.Now().Unix() > cv.ExpireAt
+	return time.Now().Unix() >= cv.ExpireAt
+}
+
+// Hits 写入后的命中次数
+func (cv CacheValue) Hits() int64 {
+	if cv.hits == nil {
+		return 0
+	}
+	return cv.hits.Load()
 }
 
+// Stale 返回过期应答副本，TTL 设为 ttl
+func (cv CacheValue) Stale(ttl time.Duration) *dns.Msg {
+	msg := cv.M.Copy()
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				rr.Header().Ttl = uint32(ttl.Seconds())
+			}
+		}
+	}
+	return msg
+}
+
+// Msg 返回应答副本，TTL 减去已缓存的时长
+func (cv CacheValue) Msg() *dns.Msg {
+	msg := cv.M.Copy()
+	age := time.Now().Unix() - cv.StoredAt
+	if age <= 0 {
+		return msg
+	}
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			// OPT 的 TTL 字段为扩展标志
+			if hdr.Rrtype == dns.TypeOPT {
+				continue
+			}
+			if int64(hdr.Ttl) > age {
+				hdr.Ttl -= uint32(age)
+			} else {
+				hdr.Ttl = 0
+			}
+		}
+	}
+	return msg
+}
+
+// Stats 缓存统计
+type Stats struct {
+	// 条目数
+	Entries int `json:"entries"`
+	// 条目占用字节数
+	Bytes int64 `json:"bytes"`
+	// 最大字节数
+	MaxBytes int64 `json:"max_bytes"`
+	// 写入的条目数
+	Added uint64 `json:"added"`
+	// 覆盖写入的条目数
+	Updated uint64 `json:"updated"`
+	// 移除的条目数，包括容量淘汰、过期与删除
+	Evicted uint64 `json:"evicted"`
+	// 容量不足被拒绝写入的条目数
+	Rejected uint64 `json:"rejected"`
+	// 写缓冲满被丢弃的条目数
+	Dropped uint64 `json:"dropped"`
+	// ristretto 命中次数、未命中次数与命中率
+	Hits     uint64  `json:"hits"`
+	Misses   uint64  `json:"misses"`
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+// requestArgument 刷新请求，addr 为写入条目的客户端地址
 type requestArgument struct {
-	domain string
-	qtype  uint16
-	addr   string
+	key  Key
+	addr string
 }
 
 type Cache struct {
-	opts      *Options
-	query     adapter.DnsQuery
-	cache     *ristretto.Cache[string, CacheValue]
+	opts  *Options
+	query adapter.DnsQuery
+	// 以缓存键的哈希值为键，读取时比较缓存键以排除哈希冲突
+	cache     *ristretto.Cache[uint64, CacheValue]
 	wait      sync.WaitGroup
-	requestCh chan *requestArgument
+	requestCh chan requestArgument
+	closeCh   chan struct{}
+	// 等待刷新的缓存键，避免重复刷新
+	refreshing sync.Map
+
+	prefetchAccess sync.Mutex
+	prefetchQueue  prefetchQueue
+	prefetchItems  map[uint64]*prefetchItem
+	// 预取队列上限，按预期条目数（max-counters 的 1/10）计算
+	prefetchLimit int
+	prefetchWake  chan struct{}
+
+	// ristretto 不支持遍历，保存快照时使用的缓存键
+	keysAccess sync.Mutex
+	keys       map[uint64]struct{}
 }
 
 func New(opts *Options) (*Cache, error) {
-	cache, err := ristretto.NewCache(&ristretto.Config[string, CacheValue]{
-		NumCounters: opts.MaxCounters, // number of keys to track frequency of (10M).
-		MaxCost:     opts.MaxCost,     // maximum cost of cache (1GB).
-		BufferItems: opts.BufferItems, // number of keys per Get buffer.
+	c := &Cache{
+		opts:          opts,
+		requestCh:     make(chan requestArgument, max(opts.PrefetchQueue, 1)),
+		closeCh:       make(chan struct{}),
+		prefetchItems: make(map[uint64]*prefetchItem),
+		prefetchLimit: int(max(opts.MaxCounters/10, 1)),
+		prefetchWake:  make(chan struct{}, 1),
+		keys:          make(map[uint64]struct{}),
+	}
+	onExit := func(item *ristretto.Item[CacheValue]) {
+		c.untrack(item.Key)
+	}
+	cache, err := ristretto.NewCache(&ristretto.Config[uint64, CacheValue]{
+		NumCounters: opts.MaxCounters,    // number of keys to track frequency of (10M).
+		MaxCost:     int64(opts.MaxCost), // maximum cost of cache in bytes.
+		BufferItems: opts.BufferItems,    // number of keys per Get buffer.
+		Metrics:     true,
+		OnEvict:     onExit,
+		OnReject:    onExit,
 	})
 	if err != nil {
 		return nil, err
 	}
-	return &Cache{
-		opts:      opts,
-		cache:     cache,
-		requestCh: make(chan *requestArgument, 256),
-	}, nil
+	c.cache = cache
+	metrics.SetCacheStats(func() metrics.CacheStats {
+		stats := c.Stats()
+		return metrics.CacheStats{Entries: stats.Entries, Bytes: stats.Bytes, Evicted: stats.Evicted}
+	})
+	return c, nil
 }
 
 func (c *Cache) SetQuery(query adapter.DnsQuery) {
 	c.query = query
 }
 
+// StaleTTL 过期应答的 TTL
+func (c *Cache) StaleTTL() time.Duration {
+	return c.opts.StaleTTL
+}
+
+// StaleTimeout 存在过期应答时等待上游的时长
+func (c *Cache) StaleTimeout() time.Duration {
+	return c.opts.StaleTimeout
+}
+
+// StaleRecheck 上游刷新失败后直接返回过期应答的时长
+func (c *Cache) StaleRecheck() time.Duration {
+	return c.opts.StaleRecheck
+}
+
+// Stats 缓存统计，写入为异步操作，统计可能略有滞后
+func (c *Cache) Stats() Stats {
+	c.keysAccess.Lock()
+	entries := len(c.keys)
+	c.keysAccess.Unlock()
+	m := c.cache.Metrics
+	return Stats{
+		Entries:  entries,
+		Bytes:    int64(m.CostAdded() - m.CostEvicted()),
+		MaxBytes: c.cache.MaxCost(),
+		Added:    m.KeysAdded(),
+		Updated:  m.KeysUpdated(),
+		Evicted:  m.KeysEvicted(),
+		Rejected: m.SetsRejected(),
+		Dropped:  m.SetsDropped(),
+		Hits:     m.Hits(),
+		Misses:   m.Misses(),
+		HitRatio: m.Ratio(),
+	}
+}
+
 func (c *Cache) Start() (err error) {
+	if c.opts.Snapshot != "" {
+		// 快照损坏时冷启动
+		if err := c.loadSnapshot(); err != nil {
+			slog.Warn("load cache snapshot failed", "path", c.opts.Snapshot, "error", err)
+		}
+		if c.opts.SnapshotInterval > 0 {
+			c.wait.Add(1)
+			go c.snapshotLoop()
+		}
+	}
+	c.wait.Add(1)
+	go c.prefetchLoop()
 	for i := 0; i < c.opts.Threads; i++ {
 		c.wait.Add(1)
 		go c.handleUpdate()
@@ -83,70 +263,207 @@ func (c *Cache) Start() (err error) {
 
 func (c *Cache) Close() {
 	slog.Debug("cache close")
-	close(c.requestCh)
+	close(c.closeCh)
 	c.wait.Wait()
+	if c.opts.Snapshot != "" {
+		if err := c.saveSnapshot(); err != nil {
+			slog.Error("save cache snapshot failed", "path", c.opts.Snapshot, "error", err)
+		}
+	}
 	c.cache.Close()
 }
 
-func (c *Cache) Set(domain string, qtype uint16, msg *dns.Msg, addr string) {
-	ok := c.cache.SetWithTTL(fmt.Sprintf("%s-%d", domain, qtype), CacheValue{
-		M:        msg.Copy(),
-		Addr:     addr,
-		ExpireAt: time.Now().Add(c.opts.RefreshTTL).Unix(),
-	}, 1, c.opts.TTL)
+// lifetime 缓存时长，正常应答取记录的最小 TTL，否定应答取 SOA 的 TTL 与 MINIMUM 中较小者（RFC 2308），
+// 返回 0 表示不缓存
+func (c *Cache) lifetime(msg *dns.Msg) time.Duration {
+	switch {
+	case msg.Rcode == dns.RcodeSuccess && len(msg.Answer) > 0:
+		ttl := minTTL(msg.Answer, msg.Ns)
+		return min(max(time.Duration(ttl)*time.Second, c.opts.MinTTL), c.opts.MaxTTL)
+	case msg.Rcode == dns.RcodeSuccess || msg.Rcode == dns.RcodeNameError:
+		for _, rr := range msg.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				ttl := min(soa.Hdr.Ttl, soa.Minttl)
+				return min(max(time.Duration(ttl)*time.Second, c.opts.MinTTL), c.opts.NegativeTTL)
+			}
+		}
+	}
+	// 无 SOA 的否定应答与其他错误不缓存
+	return 0
+}
+
+// clampTTL 写入时按缓存时长调整记录的 TTL，返回给客户端的 TTL 由此递减：
+// 记录的 TTL 限制在 min-ttl 与 max-ttl 之间，否定应答不超过缓存时长（RFC 2308 3）
+func (c *Cache) clampTTL(msg *dns.Msg, lifetime time.Duration) {
+	floor := uint32(c.opts.MinTTL.Seconds())
+	ceil := uint32(c.opts.MaxTTL.Seconds())
+	if len(msg.Answer) == 0 {
+		ceil = uint32(lifetime.Seconds())
+	}
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			// OPT 的 TTL 字段为扩展标志
+			if hdr := rr.Header(); hdr.Rrtype != dns.TypeOPT {
+				hdr.Ttl = min(max(hdr.Ttl, floor), ceil)
+			}
+		}
+	}
+}
+
+func minTTL(sections ...[]dns.RR) uint32 {
+	ttl := uint32(math.MaxUint32)
+	for _, section := range sections {
+		for _, rr := range section {
+			ttl = min(ttl, rr.Header().Ttl)
+		}
+	}
+	return ttl
+}
+
+// cost 条目成本，取打包后的应答大小与缓存键长度之和
+func cost(cv CacheValue) int64 {
+	return int64(cv.M.Len() + len(cv.key.Name) + len(cv.key.Scope))
+}
+
+// set 写入条目，ttl 为条目在缓存中的保留时长
+func (c *Cache) set(k Key, cv CacheValue, ttl time.Duration) bool {
+	cv.key = k
+	cv.hits = new(atomic.Int64)
+	h := k.hash()
+	if !c.cache.SetWithTTL(h, cv, cost(cv), ttl) {
+		return false
+	}
+	c.track(h)
+	c.schedule(h, cv.PrefetchAt, cv.hits)
+	return true
+}
+
+// Set 写入应答，msg 的 TTL 按缓存时长调整（见 clampTTL），返回给客户端的应答与缓存中的一致
+func (c *Cache) Set(k Key, msg *dns.Msg, addr string) {
+	lifetime := c.lifetime(msg)
+	if lifetime < time.Second {
+		return
+	}
+	now := time.Now()
+	c.clampTTL(msg, lifetime)
+	ok := c.set(k, CacheValue{
+		M:          msg.Copy(),
+		Addr:       addr,
+		StoredAt:   now.Unix(),
+		ExpireAt:   now.Add(lifetime).Unix(),
+		PrefetchAt: c.prefetchAt(now, lifetime),
+	}, lifetime+c.opts.MaxStale)
 	if !ok {
-		slog.Warn("cache set failed", "domain", domain, "qtype", qtype)
+		slog.Warn("cache set failed", "key", k)
 	}
 }
 
-func (c *Cache) Get(domain string, qtype uint16) (CacheValue, bool) {
-	return c.cache.Get(fmt.Sprintf("%s-%d", domain, qtype))
+func (c *Cache) Get(k Key) (CacheValue, bool) {
+	return c.get(k.hash(), k)
+}
+
+// get 按哈希值读取条目，缓存键不同时视为未命中
+func (c *Cache) get(h uint64, k Key) (CacheValue, bool) {
+	cv, ok := c.cache.Get(h)
+	if !ok || cv.key != k {
+		return CacheValue{}, false
+	}
+	return cv, true
 }
 
-func (c *Cache) GetAndUpdate(domain string, qtype uint16, addr string) (CacheValue, bool) {
-	cv, ok := c.Get(domain, qtype)
+// GetAndUpdate 查询缓存并记录命中次数，命中次数决定条目是否在过期前预取；
+// 启用 serve-stale 时可能返回过期条目，由调用方查询上游
+func (c *Cache) GetAndUpdate(k Key) (CacheValue, bool) {
+	cv, ok := c.Get(k)
+	if ok && cv.IsExpired() {
+		if c.opts.MaxStale > 0 {
+			metrics.ObserveCache(metrics.CacheStale)
+			return cv, true
+		}
+		ok = false
+	}
 	if !ok {
+		metrics.ObserveCache(metrics.CacheMiss)
 		return cv, false
 	}
-	if cv.IsExpired() {
-		// 更新
-		c.requestCh <- &requestArgument{
-			domain: domain,
-			qtype:  qtype,
-			addr:   addr,
+	metrics.ObserveCache(metrics.CacheHit)
+	cv.hits.Add(1)
+	return cv, true
+}
+
+func (c *Cache) Del(k Key) {
+	h := k.hash()
+	if _, ok := c.get(h, k); !ok {
+		return
+	}
+	c.cache.Del(h)
+	c.untrack(h)
+}
+
+// Purge 删除域名为 name 的所有条目（不区分隔离域、DO/CD 标志与 ECS 子网），qtype 为 0 时删除所有类型，返回删除的条目数
+func (c *Cache) Purge(name string, qtype uint16) int {
+	name = strings.ToLower(dns.Fqdn(name))
+	c.keysAccess.Lock()
+	keys := make([]uint64, 0, len(c.keys))
+	for key := range c.keys {
+		keys = append(keys, key)
+	}
+	c.keysAccess.Unlock()
+
+	var n int
+	for _, h := range keys {
+		cv, ok := c.cache.Get(h)
+		if !ok {
+			continue
+		}
+		if cv.key.Name != name || (qtype != 0 && cv.key.Qtype != qtype) {
+			continue
 		}
+		c.cache.Del(h)
+		c.untrack(h)
+		n++
 	}
-	return cv, true
+	return n
 }
 
-func (c *Cache) Del(domain string, qtype uint16) {
-	c.cache.Del(fmt.Sprintf("%s-%d", domain, qtype))
+// Clear 清空缓存
+func (c *Cache) Clear() {
+	c.cache.Clear()
+	c.keysAccess.Lock()
+	clear(c.keys)
+	c.keysAccess.Unlock()
+	c.prefetchAccess.Lock()
+	c.prefetchQueue = nil
+	clear(c.prefetchItems)
+	c.prefetchAccess.Unlock()
 }
 
 func (c *Cache) handleUpdate() {
 	defer c.wait.Done()
 
 	for {
-		args, ok := <-c.requestCh
-		if !ok {
+		select {
+		case <-c.closeCh:
 			return
+		case args := <-c.requestCh:
+			c.update(args)
+			c.refreshing.Delete(args.key.hash())
 		}
+	}
+}
 
-		if c.query == nil {
-			slog.Error("cache dnsQuery is nil")
-			continue
-		}
+// update 后台刷新缓存条目
+func (c *Cache) update(args requestArgument) {
+	if c.query == nil {
+		slog.Error("cache dnsQuery is nil")
+		return
+	}
 
-		// 从 outbound 获取
-		req := new(dns.Msg)
-		req.SetQuestion(args.domain, args.qtype)
-		msg, _, err := c.query.Resolve(req, net.ParseIP(args.addr))
-		if err != nil {
-			slog.Error("resolve failed", "domain", args.domain, "qtype", args.qtype, "error", err)
-			continue
-		}
-		// 缓存
-		c.Set(args.domain, args.qtype, msg, args.addr)
-		slog.Debug("cache update", "domain", args.domain, "qtype", args.qtype, "ttl", utils.GetMinTTL(msg))
+	// 从 outbound 获取，Resolve 写入缓存
+	msg, _, err := c.query.Resolve(args.key.Request(), args.key.Scope, net.ParseIP(args.addr))
+	if err != nil {
+		slog.Error("resolve failed", "key", args.key, "error", err)
+		return
 	}
+	slog.Debug("cache update", "key", args.key, "ttl", utils.GetMinTTL(msg))
 }
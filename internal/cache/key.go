@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"net/netip"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+var keySeed = maphash.MakeSeed()
+
+// Key 缓存键
+type Key struct {
+	// 域名，小写的完整域名
+	Name   string
+	Qclass uint16
+	Qtype  uint16
+	// DNSSEC OK，为 true 时应答包含签名记录
+	DO bool
+	// Checking Disabled，为 true 时应答未经 DNSSEC 校验
+	CD bool
+	// 缓存隔离域（由路由决定），不同隔离域互不共享缓存
+	Scope string
+	// ECS 子网，应答与子网无关时无效
+	Subnet netip.Prefix
+}
+
+// NewKey 按请求的问题与标志生成缓存键
+func NewKey(req *dns.Msg, scope string) Key {
+	q := req.Question[0]
+	k := Key{
+		Name:   strings.ToLower(dns.Fqdn(q.Name)),
+		Qclass: q.Qclass,
+		Qtype:  q.Qtype,
+		CD:     req.CheckingDisabled,
+		Scope:  scope,
+	}
+	if opt := req.IsEdns0(); opt != nil {
+		k.DO = opt.Do()
+	}
+	return k
+}
+
+// hash 缓存键的哈希值，不分配内存
+func (k Key) hash() uint64 {
+	var h maphash.Hash
+	h.SetSeed(keySeed)
+	h.WriteString(k.Name)
+	var buf [8]byte
+	binary.LittleEndian.PutUint16(buf[0:], k.Qclass)
+	binary.LittleEndian.PutUint16(buf[2:], k.Qtype)
+	if k.DO {
+		buf[4] = 1
+	}
+	if k.CD {
+		buf[5] = 1
+	}
+	// 域名长度不超过 255，用于区分域名与隔离域的边界
+	buf[6] = byte(len(k.Name))
+	h.Write(buf[:])
+	h.WriteString(k.Scope)
+	if k.Subnet.IsValid() {
+		addr := k.Subnet.Addr().As16()
+		h.Write(addr[:])
+		h.WriteByte(byte(k.Subnet.Bits()))
+	}
+	return h.Sum64()
+}
+
+// Request 按缓存键构造查询请求，用于刷新缓存
+func (k Key) Request() *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion(k.Name, k.Qtype)
+	req.Question[0].Qclass = k.Qclass
+	req.CheckingDisabled = k.CD
+	if !k.DO && !k.Subnet.IsValid() {
+		return req
+	}
+	req.SetEdns0(dns.DefaultMsgSize, k.DO)
+	if k.Subnet.IsValid() {
+		e := &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        2,
+			SourceNetmask: uint8(k.Subnet.Bits()),
+			Address:       k.Subnet.Addr().AsSlice(),
+		}
+		if k.Subnet.Addr().Is4() {
+			e.Family = 1
+		}
+		opt := req.IsEdns0()
+		opt.Option = append(opt.Option, e)
+	}
+	return req
+}
+
+func (k Key) String() string {
+	var b strings.Builder
+	b.WriteString(k.Name)
+	b.WriteByte(' ')
+	b.WriteString(dns.Class(k.Qclass).String())
+	b.WriteByte(' ')
+	b.WriteString(dns.Type(k.Qtype).String())
+	if k.DO {
+		b.WriteString(" +do")
+	}
+	if k.CD {
+		b.WriteString(" +cd")
+	}
+	if k.Scope != "" {
+		b.WriteString(" " + k.Scope)
+	}
+	if k.Subnet.IsValid() {
+		b.WriteString(" " + k.Subnet.String())
+	}
+	return b.String()
+}
@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"container/heap"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/taodev/godns/internal/metrics"
+)
+
+type prefetchItem struct {
+	key uint64
+	at  int64
+	// 条目的命中次数，队列满时丢弃命中最少的条目
+	hits  *atomic.Int64
+	index int
+}
+
+// prefetchQueue 按预取时间排序的最小堆
+type prefetchQueue []*prefetchItem
+
+func (q prefetchQueue) Len() int           { return len(q) }
+func (q prefetchQueue) Less(i, j int) bool { return q[i].at < q[j].at }
+func (q prefetchQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+func (q *prefetchQueue) Push(x any) {
+	item := x.(*prefetchItem)
+	item.index = len(*q)
+	*q = append(*q, item)
+}
+func (q *prefetchQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return item
+}
+
+// prefetchSamples 预取队列满时抽样比较命中次数的条目数
+const prefetchSamples = 5
+
+// prefetchAt 预取时间，剩余缓存时长低于 prefetch-ratio 时预取，缓存时长过短时返回 0
+func (c *Cache) prefetchAt(now time.Time, lifetime time.Duration) int64 {
+	if c.opts.PrefetchHits <= 0 {
+		return 0
+	}
+	window := max(time.Duration(float64(lifetime)*c.opts.PrefetchRatio), time.Second)
+	if lifetime-window < time.Second {
+		return 0
+	}
+	return now.Add(lifetime - window).Unix()
+}
+
+// schedule 安排条目在预取时间检查是否需要预取，重新写入的条目更新原有计划
+func (c *Cache) schedule(key uint64, at int64, hits *atomic.Int64) {
+	if at <= 0 {
+		c.unschedule(key)
+		return
+	}
+	c.prefetchAccess.Lock()
+	if item, ok := c.prefetchItems[key]; ok {
+		item.at, item.hits = at, hits
+		heap.Fix(&c.prefetchQueue, item.index)
+	} else {
+		if len(c.prefetchQueue) >= c.prefetchLimit {
+			c.dropColdest()
+		}
+		item := &prefetchItem{key: key, at: at, hits: hits}
+		heap.Push(&c.prefetchQueue, item)
+		c.prefetchItems[key] = item
+	}
+	first := c.prefetchQueue[0].at == at
+	c.prefetchAccess.Unlock()
+	if first {
+		select {
+		case c.prefetchWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// unschedule 条目被淘汰或删除时移除预取计划
+func (c *Cache) unschedule(key uint64) {
+	c.prefetchAccess.Lock()
+	if item, ok := c.prefetchItems[key]; ok {
+		heap.Remove(&c.prefetchQueue, item.index)
+		delete(c.prefetchItems, key)
+	}
+	c.prefetchAccess.Unlock()
+}
+
+// dropColdest 预取队列达到上限时抽样丢弃命中次数最少的条目，次数相同时丢弃预取时间较晚的条目，需持有 prefetchAccess
+func (c *Cache) dropColdest() {
+	var coldest *prefetchItem
+	n := 0
+	// map 遍历顺序随机，相当于随机抽样
+	for _, item := range c.prefetchItems {
+		if coldest == nil || item.hits.Load() < coldest.hits.Load() ||
+			(item.hits.Load() == coldest.hits.Load() && item.at > coldest.at) {
+			coldest = item
+		}
+		if n++; n >= prefetchSamples {
+			break
+		}
+	}
+	if coldest == nil {
+		return
+	}
+	heap.Remove(&c.prefetchQueue, coldest.index)
+	delete(c.prefetchItems, coldest.key)
+	metrics.ObservePrefetchDropped()
+}
+
+// prefetchLoop 到达预取时间时将热门条目放入刷新队列，冷门条目自然过期
+func (c *Cache) prefetchLoop() {
+	defer c.wait.Done()
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		var due []*prefetchItem
+		next := time.Hour
+		now := time.Now().Unix()
+		c.prefetchAccess.Lock()
+		for c.prefetchQueue.Len() > 0 && c.prefetchQueue[0].at <= now {
+			item := heap.Pop(&c.prefetchQueue).(*prefetchItem)
+			delete(c.prefetchItems, item.key)
+			due = append(due, item)
+		}
+		if c.prefetchQueue.Len() > 0 {
+			next = time.Duration(c.prefetchQueue[0].at-now) * time.Second
+		}
+		c.prefetchAccess.Unlock()
+
+		for _, item := range due {
+			c.prefetch(item)
+		}
+
+		timer.Reset(next)
+		select {
+		case <-c.closeCh:
+			return
+		case <-timer.C:
+		case <-c.prefetchWake:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		}
+	}
+}
+
+// prefetch 条目仍在缓存且命中次数足够时放入刷新队列，队列满时丢弃
+func (c *Cache) prefetch(item *prefetchItem) {
+	cv, ok := c.cache.Get(item.key)
+	// 条目已被淘汰或重新写入
+	if !ok || cv.PrefetchAt != item.at {
+		return
+	}
+	if cv.hits.Load() < int64(c.opts.PrefetchHits) {
+		return
+	}
+	if _, loaded := c.refreshing.LoadOrStore(item.key, struct{}{}); loaded {
+		return
+	}
+	select {
+	case c.requestCh <- requestArgument{key: cv.key, addr: cv.Addr}:
+		metrics.ObserveCache(metrics.CacheRefresh)
+	default:
+		c.refreshing.Delete(item.key)
+		metrics.ObservePrefetchDropped()
+		slog.Debug("prefetch queue full", "key", cv.key)
+	}
+}
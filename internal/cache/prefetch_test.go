@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func newTestCache(t *testing.T, maxCounters int64) *Cache {
+	t.Helper()
+	c, err := New(&Options{
+		MaxCounters:   maxCounters,
+		MaxCost:       1 << 20,
+		BufferItems:   64,
+		MaxTTL:        24 * time.Hour,
+		NegativeTTL:   time.Hour,
+		PrefetchHits:  2,
+		PrefetchRatio: 0.1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func testMsg(name string, ttl uint32) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, dns.TypeA)
+	rr, _ := dns.NewRR(name + " 300 IN A 192.0.2.1")
+	rr.Header().Ttl = ttl
+	msg.Answer = append(msg.Answer, rr)
+	return msg
+}
+
+func (c *Cache) prefetchLen() int {
+	c.prefetchAccess.Lock()
+	defer c.prefetchAccess.Unlock()
+	if len(c.prefetchQueue) != len(c.prefetchItems) {
+		panic("prefetch queue and index out of sync")
+	}
+	return len(c.prefetchQueue)
+}
+
+// 重新写入的条目更新原有预取计划，删除的条目移除预取计划
+func TestPrefetchReschedule(t *testing.T) {
+	c := newTestCache(t, 1000)
+	k := Key{Name: "www.example.com.", Qtype: dns.TypeA}
+	for range 10 {
+		c.Set(k, testMsg("www.example.com.", 300), "")
+	}
+	if n := c.prefetchLen(); n != 1 {
+		t.Fatalf("prefetch items = %d, want 1", n)
+	}
+	c.cache.Wait()
+	c.Del(k)
+	if n := c.prefetchLen(); n != 0 {
+		t.Fatalf("prefetch items after delete = %d, want 0", n)
+	}
+
+	// 缓存时长过短不预取时移除原有计划
+	c.Set(k, testMsg("www.example.com.", 300), "")
+	c.Set(k, testMsg("www.example.com.", 1), "")
+	if n := c.prefetchLen(); n != 0 {
+		t.Fatalf("prefetch items after short ttl = %d, want 0", n)
+	}
+}
+
+// 预取队列不超过上限，超出时丢弃命中次数最少的条目
+func TestPrefetchLimit(t *testing.T) {
+	c := newTestCache(t, 100)
+	hot := Key{Name: "hot.example.com.", Qtype: dns.TypeA}
+	c.Set(hot, testMsg("hot.example.com.", 300), "")
+	c.cache.Wait()
+	for range 5 {
+		if _, ok := c.GetAndUpdate(hot); !ok {
+			t.Fatal("hot entry not cached")
+		}
+	}
+	for i := range 100 {
+		name := dns.Fqdn(string(rune('a'+i%26)) + string(rune('a'+i/26)) + ".example.com")
+		c.Set(Key{Name: name, Qtype: dns.TypeA}, testMsg(name, 300), "")
+	}
+	if n := c.prefetchLen(); n != c.prefetchLimit {
+		t.Fatalf("prefetch items = %d, want %d", n, c.prefetchLimit)
+	}
+	c.prefetchAccess.Lock()
+	_, ok := c.prefetchItems[hot.hash()]
+	c.prefetchAccess.Unlock()
+	if !ok {
+		t.Fatal("hot entry dropped from prefetch queue")
+	}
+}
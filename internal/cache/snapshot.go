@@ -0,0 +1,211 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// 快照格式版本，CacheValue 或缓存键变化时递增，加载时丢弃不兼容的快照
+const snapshotVersion = 3
+
+type snapshotHeader struct {
+	Version int
+	SavedAt int64
+}
+
+type snapshotEntry struct {
+	Name       string
+	Qclass     uint16
+	Qtype      uint16
+	DO         bool
+	CD         bool
+	Scope      string
+	Subnet     string
+	Msg        []byte
+	Addr       string
+	StoredAt   int64
+	ExpireAt   int64
+	PrefetchAt int64
+}
+
+// track 记录缓存键，用于保存快照与按域名删除
+func (c *Cache) track(key uint64) {
+	c.keysAccess.Lock()
+	c.keys[key] = struct{}{}
+	c.keysAccess.Unlock()
+}
+
+// untrack 条目被淘汰、拒绝或删除时移除缓存键与预取计划
+func (c *Cache) untrack(key uint64) {
+	c.keysAccess.Lock()
+	delete(c.keys, key)
+	c.keysAccess.Unlock()
+	c.unschedule(key)
+}
+
+// saveSnapshot 保存缓存快照，先写临时文件再替换
+func (c *Cache) saveSnapshot() (err error) {
+	c.keysAccess.Lock()
+	keys := make([]uint64, 0, len(c.keys))
+	for key := range c.keys {
+		keys = append(keys, key)
+	}
+	c.keysAccess.Unlock()
+
+	tmp := c.opts.Snapshot + ".tmp"
+	if err = os.MkdirAll(filepath.Dir(tmp), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	w := bufio.NewWriter(f)
+	enc := gob.NewEncoder(w)
+	if err = enc.Encode(snapshotHeader{Version: snapshotVersion, SavedAt: time.Now().Unix()}); err != nil {
+		return err
+	}
+	var count int
+	for _, key := range keys {
+		cv, ok := c.cache.Get(key)
+		if !ok {
+			c.untrack(key)
+			continue
+		}
+		data, err := cv.M.Pack()
+		if err != nil {
+			continue
+		}
+		var subnet string
+		if cv.key.Subnet.IsValid() {
+			subnet = cv.key.Subnet.String()
+		}
+		if err = enc.Encode(snapshotEntry{
+			Name:       cv.key.Name,
+			Qclass:     cv.key.Qclass,
+			Qtype:      cv.key.Qtype,
+			DO:         cv.key.DO,
+			CD:         cv.key.CD,
+			Scope:      cv.key.Scope,
+			Subnet:     subnet,
+			Msg:        data,
+			Addr:       cv.Addr,
+			StoredAt:   cv.StoredAt,
+			ExpireAt:   cv.ExpireAt,
+			PrefetchAt: cv.PrefetchAt,
+		}); err != nil {
+			return err
+		}
+		count++
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, c.opts.Snapshot); err != nil {
+		return err
+	}
+	slog.Debug("cache snapshot saved", "path", c.opts.Snapshot, "entries", count)
+	return nil
+}
+
+// loadSnapshot 加载缓存快照，丢弃已超过保留时长的条目
+func (c *Cache) loadSnapshot() error {
+	f, err := os.Open(c.opts.Snapshot)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	dec := gob.NewDecoder(bufio.NewReader(f))
+	var header snapshotHeader
+	if err = dec.Decode(&header); err != nil {
+		return fmt.Errorf("invalid cache snapshot: %w", err)
+	}
+	if header.Version != snapshotVersion {
+		slog.Warn("discard incompatible cache snapshot", "path", c.opts.Snapshot, "version", header.Version)
+		return nil
+	}
+
+	var loaded, dropped int
+	now := time.Now()
+	for {
+		var entry snapshotEntry
+		if err = dec.Decode(&entry); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("invalid cache snapshot: %w", err)
+		}
+		ttl := time.Unix(entry.ExpireAt, 0).Add(c.opts.MaxStale).Sub(now)
+		msg := new(dns.Msg)
+		if ttl <= 0 || msg.Unpack(entry.Msg) != nil {
+			dropped++
+			continue
+		}
+		k := Key{
+			Name:   entry.Name,
+			Qclass: entry.Qclass,
+			Qtype:  entry.Qtype,
+			DO:     entry.DO,
+			CD:     entry.CD,
+			Scope:  entry.Scope,
+		}
+		if entry.Subnet != "" {
+			if k.Subnet, err = netip.ParsePrefix(entry.Subnet); err != nil {
+				dropped++
+				continue
+			}
+		}
+		c.set(k, CacheValue{
+			M:          msg,
+			Addr:       entry.Addr,
+			StoredAt:   entry.StoredAt,
+			ExpireAt:   entry.ExpireAt,
+			PrefetchAt: entry.PrefetchAt,
+		}, ttl)
+		loaded++
+	}
+	c.cache.Wait()
+	slog.Info("cache snapshot loaded", "path", c.opts.Snapshot, "entries", loaded, "dropped", dropped)
+	return nil
+}
+
+// snapshotLoop 定时保存快照
+func (c *Cache) snapshotLoop() {
+	defer c.wait.Done()
+	ticker := time.NewTicker(c.opts.SnapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closeCh:
+			return
+		case <-ticker.C:
+			if err := c.saveSnapshot(); err != nil {
+				slog.Error("save cache snapshot failed", "path", c.opts.Snapshot, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package dnssec
+
+import (
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// 区域分割点类型
+const (
+	// 不是区域分割点
+	cutNone = iota
+	// 已签名的子区域
+	cutSecure
+	// 未签名的子区域，其下的名称不受 DNSSEC 保护
+	cutInsecure
+	// 名称不存在，其下的名称也不存在
+	cutNX
+)
+
+// 已校验缓存时长上限
+const maxCutTTL = 24 * time.Hour
+
+// cut 名称是否为区域分割点的校验结果，安全区域带已校验的 DNSKEY
+type cut struct {
+	kind   int
+	keys   []*dns.DNSKEY
+	expire time.Time
+}
+
+// cutKey 区域分割点的缓存键
+type cutKey struct {
+	tag  string
+	name string
+}
+
+// zone 名称所在区域，insecure 时 keys 为空
+type zone struct {
+	name     string
+	keys     []*dns.DNSKEY
+	insecure bool
+}
+
+// rrset 同名同类型的记录与覆盖它们的签名
+type rrset struct {
+	name  string
+	rtype uint16
+	rrs   []dns.RR
+	sigs  []*dns.RRSIG
+}
+
+// groupRRsets 按名称与类型分组，签名归入覆盖的记录集
+func groupRRsets(section []dns.RR) []*rrset {
+	var sets []*rrset
+	for _, rr := range section {
+		hdr := rr.Header()
+		if hdr.Rrtype == dns.TypeOPT || hdr.Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		name := strings.ToLower(hdr.Name)
+		if set := findRRset(sets, name, hdr.Rrtype); set != nil {
+			set.rrs = append(set.rrs, rr)
+			continue
+		}
+		sets = append(sets, &rrset{name: name, rtype: hdr.Rrtype, rrs: []dns.RR{rr}})
+	}
+	for _, rr := range section {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			if set := findRRset(sets, strings.ToLower(sig.Hdr.Name), sig.TypeCovered); set != nil {
+				set.sigs = append(set.sigs, sig)
+			}
+		}
+	}
+	return sets
+}
+
+func findRRset(sets []*rrset, name string, rtype uint16) *rrset {
+	for _, set := range sets {
+		if set.name == name && set.rtype == rtype {
+			return set
+		}
+	}
+	return nil
+}
+
+func parent(name string) string {
+	if name == "." {
+		return name
+	}
+	_, p, _ := strings.Cut(name, ".")
+	return dns.Fqdn(p)
+}
+
+// ttl 记录集的缓存时长，不超过签名的过期时间
+func (c *context) ttl(set *rrset) time.Duration {
+	ttl := maxCutTTL
+	for _, rr := range set.rrs {
+		ttl = min(ttl, time.Duration(rr.Header().Ttl)*time.Second)
+	}
+	for _, sig := range set.sigs {
+		ttl = min(ttl, time.Duration(sig.OrigTtl)*time.Second, time.Unix(int64(sig.Expiration), 0).Sub(c.now))
+	}
+	return ttl
+}
+
+// verify 校验记录集，返回通过校验的签名；记录集所在区域未签名时返回 nil
+func (c *context) verify(set *rrset) (*dns.RRSIG, error) {
+	if len(set.sigs) == 0 {
+		// DS 记录属于上级区域
+		name := set.name
+		if set.rtype == dns.TypeDS {
+			name = parent(name)
+		}
+		z, err := c.zone(name)
+		if err != nil {
+			return nil, err
+		}
+		if z.insecure {
+			return nil, nil
+		}
+		return nil, bogus(dns.ExtendedErrorCodeRRSIGsMissing, set.name, "missing signature for %s", dns.TypeToString[set.rtype])
+	}
+	var lastErr error
+	for _, sig := range set.sigs {
+		signer := strings.ToLower(sig.SignerName)
+		if !dns.IsSubDomain(signer, set.name) {
+			continue
+		}
+		z, err := c.zone(signer)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if z.insecure {
+			return nil, nil
+		}
+		if z.name != signer {
+			lastErr = bogus(dns.ExtendedErrorCodeDNSBogus, set.name, "signer %s is not a zone apex", signer)
+			continue
+		}
+		if err := c.check(set, sig, z.keys); err != nil {
+			lastErr = err
+			continue
+		}
+		return sig, nil
+	}
+	if lastErr == nil {
+		lastErr = bogus(dns.ExtendedErrorCodeDNSBogus, set.name, "no valid signature for %s", dns.TypeToString[set.rtype])
+	}
+	return nil, lastErr
+}
+
+// check 使用区域的 DNSKEY 校验签名
+func (c *context) check(set *rrset, sig *dns.RRSIG, keys []*dns.DNSKEY) error {
+	if !sig.ValidityPeriod(c.now) {
+		if c.now.Unix() < int64(sig.Inception) {
+			return bogus(dns.ExtendedErrorCodeSignatureNotYetValid, set.name, "signature not yet valid")
+		}
+		return bogus(dns.ExtendedErrorCodeSignatureExpired, set.name, "signature expired")
+	}
+	var found bool
+	for _, key := range keys {
+		if key.Algorithm != sig.Algorithm || key.KeyTag() != sig.KeyTag {
+			continue
+		}
+		found = true
+		if sig.Verify(key, set.rrs) == nil {
+			return nil
+		}
+	}
+	if !found {
+		return bogus(dns.ExtendedErrorCodeDNSKEYMissing, set.name, "no DNSKEY with tag %d", sig.KeyTag)
+	}
+	return bogus(dns.ExtendedErrorCodeDNSBogus, set.name, "invalid signature for %s", dns.TypeToString[set.rtype])
+}
+
+// zone 自最近的信任锚逐级向下查找名称所在的区域
+func (c *context) zone(name string) (*zone, error) {
+	name = strings.ToLower(dns.Fqdn(name))
+	anchor := name
+	for {
+		if _, ok := c.v.anchors[anchor]; ok {
+			break
+		}
+		if anchor == "." {
+			// 不在任何信任锚之下
+			return &zone{name: ".", insecure: true}, nil
+		}
+		anchor = parent(anchor)
+	}
+	ct, err := c.lookup(anchor, nil)
+	if err != nil {
+		return nil, err
+	}
+	z := &zone{name: anchor, keys: ct.keys}
+	labels := dns.SplitDomainName(name)
+	for i := len(labels) - dns.CountLabel(anchor) - 1; i >= 0; i-- {
+		child := dns.Fqdn(strings.Join(labels[i:], "."))
+		ct, err := c.lookup(child, z)
+		if err != nil {
+			return nil, err
+		}
+		switch ct.kind {
+		case cutSecure:
+			z = &zone{name: child, keys: ct.keys}
+		case cutInsecure:
+			return &zone{name: child, insecure: true}, nil
+		case cutNX:
+			return z, nil
+		}
+	}
+	return z, nil
+}
+
+// lookup 查询名称是否为区域分割点，parent 为上级区域，为空时 name 为信任锚
+func (c *context) lookup(name string, parent *zone) (*cut, error) {
+	key := cutKey{tag: c.tag, name: name}
+	c.v.access.Lock()
+	ct, ok := c.v.cuts[key]
+	c.v.access.Unlock()
+	if ok && c.now.Before(ct.expire) {
+		return ct, nil
+	}
+	v, err, _ := c.v.flight.Do(c.tag+"\x00"+name, func() (any, error) {
+		var (
+			ct  *cut
+			ttl time.Duration
+			err error
+		)
+		if parent == nil {
+			ct, ttl, err = c.anchor(name)
+		} else {
+			ct, ttl, err = c.delegation(name, parent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ct.expire = c.now.Add(ttl)
+		c.v.store(key, ct)
+		return ct, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*cut), nil
+}
+
+// store 缓存校验结果，已满时先移除过期条目
+func (v *Validator) store(key cutKey, ct *cut) {
+	v.access.Lock()
+	defer v.access.Unlock()
+	if len(v.cuts) >= v.cacheSize {
+		now := time.Now()
+		for k, old := range v.cuts {
+			if now.After(old.expire) || len(v.cuts) >= v.cacheSize {
+				delete(v.cuts, k)
+			}
+		}
+	}
+	v.cuts[key] = ct
+}
+
+// query 以 DO 标志查询上游
+func (c *context) query(name string, qtype uint16) (*dns.Msg, error) {
+	req := new(dns.Msg)
+	req.SetQuestion(name, qtype)
+	req.SetEdns0(dns.DefaultMsgSize, true)
+	resp, err := c.exchange(req)
+	if err != nil {
+		return nil, bogus(dns.ExtendedErrorCodeDNSSECIndeterminate, name, "query %s: %v", dns.TypeToString[qtype], err)
+	}
+	if resp.Truncated {
+		return nil, bogus(dns.ExtendedErrorCodeDNSSECIndeterminate, name, "%s response truncated", dns.TypeToString[qtype])
+	}
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return nil, bogus(dns.ExtendedErrorCodeDNSSECIndeterminate, name, "query %s: %s", dns.TypeToString[qtype], dns.RcodeToString[resp.Rcode])
+	}
+	return resp, nil
+}
+
+// anchor 使用信任锚校验区域的 DNSKEY
+func (c *context) anchor(name string) (*cut, time.Duration, error) {
+	var ds []*dns.DS
+	var keys []*dns.DNSKEY
+	for _, rr := range c.v.anchors[name] {
+		switch rr := rr.(type) {
+		case *dns.DS:
+			ds = append(ds, rr)
+		case *dns.DNSKEY:
+			keys = append(keys, rr)
+		}
+	}
+	trusted := func(key *dns.DNSKEY) bool {
+		for _, k := range keys {
+			if k.Algorithm == key.Algorithm && k.Protocol == key.Protocol && k.PublicKey == key.PublicKey {
+				return true
+			}
+		}
+		return matchDS(ds, key)
+	}
+	keyset, ttl, err := c.dnskeys(name, trusted)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &cut{kind: cutSecure, keys: keyset}, ttl, nil
+}
+
+// delegation 查询 DS 判断名称是否为区域分割点，DS 及其否定证明由上级区域签名
+func (c *context) delegation(name string, parent *zone) (*cut, time.Duration, error) {
+	resp, err := c.query(name, dns.TypeDS)
+	if err != nil {
+		return nil, 0, err
+	}
+	answer := groupRRsets(resp.Answer)
+	if set := findRRset(answer, name, dns.TypeDS); set != nil {
+		if err := c.verifyBy(set, parent); err != nil {
+			return nil, 0, err
+		}
+		ttl := c.ttl(set)
+		var ds []*dns.DS
+		for _, rr := range set.rrs {
+			if rr := rr.(*dns.DS); supportedDS(rr) {
+				ds = append(ds, rr)
+			}
+		}
+		// 不支持 DS 中的任何算法时视为未签名（RFC 4035 5.2）
+		if len(ds) == 0 {
+			return &cut{kind: cutInsecure}, ttl, nil
+		}
+		keys, keyTTL, err := c.dnskeys(name, func(key *dns.DNSKEY) bool { return matchDS(ds, key) })
+		if err != nil {
+			return nil, 0, err
+		}
+		return &cut{kind: cutSecure, keys: keys}, min(ttl, keyTTL), nil
+	}
+	// 别名不是区域分割点
+	if set := findRRset(answer, name, dns.TypeCNAME); set != nil {
+		if err := c.verifyBy(set, parent); err != nil {
+			return nil, 0, err
+		}
+		return &cut{kind: cutNone}, c.ttl(set), nil
+	}
+
+	// 否定应答，由 NSEC/NSEC3 证明
+	var p proofs
+	ttl := maxCutTTL
+	for _, set := range groupRRsets(resp.Ns) {
+		if set.rtype != dns.TypeNSEC && set.rtype != dns.TypeNSEC3 {
+			continue
+		}
+		if err := c.verifyBy(set, parent); err != nil {
+			return nil, 0, err
+		}
+		p.add(set)
+		ttl = min(ttl, c.ttl(set))
+	}
+	kind, err := p.delegation(name, resp.Rcode == dns.RcodeNameError)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &cut{kind: kind}, ttl, nil
+}
+
+// verifyBy 校验记录集由区域 z 签名
+func (c *context) verifyBy(set *rrset, z *zone) error {
+	var lastErr error
+	for _, sig := range set.sigs {
+		if strings.ToLower(sig.SignerName) != z.name {
+			continue
+		}
+		if lastErr = c.check(set, sig, z.keys); lastErr == nil {
+			return nil
+		}
+	}
+	if lastErr == nil {
+		lastErr = bogus(dns.ExtendedErrorCodeRRSIGsMissing, set.name, "missing signature for %s by %s", dns.TypeToString[set.rtype], z.name)
+	}
+	return lastErr
+}
+
+// dnskeys 查询并校验区域的 DNSKEY，记录集需由 trusted 认可的密钥签名
+func (c *context) dnskeys(name string, trusted func(*dns.DNSKEY) bool) ([]*dns.DNSKEY, time.Duration, error) {
+	resp, err := c.query(name, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, 0, err
+	}
+	set := findRRset(groupRRsets(resp.Answer), name, dns.TypeDNSKEY)
+	if set == nil {
+		return nil, 0, bogus(dns.ExtendedErrorCodeDNSKEYMissing, name, "missing DNSKEY")
+	}
+	var keys, entry []*dns.DNSKEY
+	for _, rr := range set.rrs {
+		key := rr.(*dns.DNSKEY)
+		if key.Flags&dns.ZONE == 0 {
+			continue
+		}
+		keys = append(keys, key)
+		if trusted(key) {
+			entry = append(entry, key)
+		}
+	}
+	if len(entry) == 0 {
+		return nil, 0, bogus(dns.ExtendedErrorCodeDNSKEYMissing, name, "no DNSKEY matches the trust anchor or DS")
+	}
+	var lastErr error
+	for _, sig := range set.sigs {
+		if lastErr = c.check(set, sig, entry); lastErr == nil {
+			return keys, c.ttl(set), nil
+		}
+	}
+	if lastErr == nil {
+		lastErr = bogus(dns.ExtendedErrorCodeRRSIGsMissing, name, "missing signature for DNSKEY")
+	}
+	return nil, 0, lastErr
+}
+
+// 支持校验的签名算法
+var algorithms = map[uint8]bool{
+	dns.RSASHA1:          true,
+	dns.RSASHA1NSEC3SHA1: true,
+	dns.RSASHA256:        true,
+	dns.RSASHA512:        true,
+	dns.ECDSAP256SHA256:  true,
+	dns.ECDSAP384SHA384:  true,
+	dns.ED25519:          true,
+}
+
+func supportedDS(ds *dns.DS) bool {
+	switch ds.DigestType {
+	case dns.SHA1, dns.SHA256, dns.SHA384:
+		return algorithms[ds.Algorithm]
+	}
+	return false
+}
+
+// matchDS 密钥与任一 DS 摘要一致
+func matchDS(ds []*dns.DS, key *dns.DNSKEY) bool {
+	for _, d := range ds {
+		if d.Algorithm != key.Algorithm || d.KeyTag != key.KeyTag() {
+			continue
+		}
+		if kd := key.ToDS(d.DigestType); kd != nil && strings.EqualFold(kd.Digest, d.Digest) {
+			return true
+		}
+	}
+	return false
+}
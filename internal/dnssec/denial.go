@@ -0,0 +1,229 @@
+package dnssec
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// NSEC3 迭代次数上限，超过时视为未签名（RFC 9276 3.2）
+const maxIterations = 150
+
+// proofs 已校验的 NSEC/NSEC3 记录
+type proofs struct {
+	nsec  []*dns.NSEC
+	nsec3 []*dns.NSEC3
+}
+
+func (p *proofs) add(set *rrset) {
+	for _, rr := range set.rrs {
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			p.nsec = append(p.nsec, rr)
+		case *dns.NSEC3:
+			if rr.Hash == dns.SHA1 {
+				p.nsec3 = append(p.nsec3, rr)
+			}
+		}
+	}
+}
+
+func (p *proofs) empty() bool {
+	return len(p.nsec) == 0 && len(p.nsec3) == 0
+}
+
+// canonicalCompare 按规范顺序比较域名（RFC 4034 6.1）
+func canonicalCompare(a, b string) int {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(la), len(lb))
+}
+
+func wildcardOf(ce string) string {
+	if ce == "." {
+		return "*."
+	}
+	return "*." + ce
+}
+
+// ancestor name 保留 n 个标签的上级名称
+func ancestor(name string, n int) string {
+	labels := dns.SplitDomainName(name)
+	return dns.Fqdn(strings.Join(labels[len(labels)-n:], "."))
+}
+
+func (p *proofs) nsecMatch(name string) *dns.NSEC {
+	for _, n := range p.nsec {
+		if strings.EqualFold(n.Hdr.Name, name) {
+			return n
+		}
+	}
+	return nil
+}
+
+// nsecCover name 位于 NSEC 记录的 owner 与 next 之间
+func (p *proofs) nsecCover(name string) *dns.NSEC {
+	for _, n := range p.nsec {
+		owner, next := n.Hdr.Name, n.NextDomain
+		if canonicalCompare(owner, name) >= 0 {
+			continue
+		}
+		// 区域中最后一条 NSEC 的 next 为区域顶点
+		if canonicalCompare(owner, next) < 0 && canonicalCompare(name, next) < 0 ||
+			canonicalCompare(owner, next) >= 0 && dns.IsSubDomain(next, name) {
+			return n
+		}
+	}
+	return nil
+}
+
+// nsecEncloser name 不存在时由覆盖它的 NSEC 推出的最近祖先
+func nsecEncloser(name string, n *dns.NSEC) string {
+	labels := max(dns.CompareDomainName(name, n.Hdr.Name), dns.CompareDomainName(name, n.NextDomain))
+	return ancestor(name, labels)
+}
+
+func (p *proofs) nsec3Match(name string) *dns.NSEC3 {
+	for _, n := range p.nsec3 {
+		if n.Match(name) {
+			return n
+		}
+	}
+	return nil
+}
+
+func (p *proofs) nsec3Cover(name string) *dns.NSEC3 {
+	for _, n := range p.nsec3 {
+		if n.Cover(name) && !n.Match(name) {
+			return n
+		}
+	}
+	return nil
+}
+
+// closestEncloser NSEC3 最近祖先证明（RFC 5155 8.3），返回最近祖先与覆盖下一级名称的 NSEC3
+func (p *proofs) closestEncloser(name string) (string, *dns.NSEC3, bool) {
+	labels := dns.SplitDomainName(name)
+	for i := 1; i <= len(labels); i++ {
+		ce := dns.Fqdn(strings.Join(labels[i:], "."))
+		if p.nsec3Match(ce) == nil {
+			continue
+		}
+		if nc := p.nsec3Cover(dns.Fqdn(strings.Join(labels[i-1:], "."))); nc != nil {
+			return ce, nc, true
+		}
+		return "", nil, false
+	}
+	return "", nil, false
+}
+
+// weak NSEC3 迭代次数过多，视为未签名
+func (p *proofs) weak() bool {
+	return slices.ContainsFunc(p.nsec3, func(n *dns.NSEC3) bool { return n.Iterations > maxIterations })
+}
+
+// nxdomain 证明名称及匹配它的通配符均不存在，insecure 表示证明依赖 opt-out
+func (p *proofs) nxdomain(name string) (ok, insecure bool) {
+	if n := p.nsecCover(name); n != nil {
+		return p.nsecCover(wildcardOf(nsecEncloser(name, n))) != nil, false
+	}
+	if p.weak() {
+		return true, true
+	}
+	ce, nc, ok := p.closestEncloser(name)
+	if !ok || p.nsec3Cover(wildcardOf(ce)) == nil {
+		return false, false
+	}
+	return true, nc.Flags&1 == 1
+}
+
+// nodata 证明名称存在但没有 qtype 类型的记录，insecure 表示证明依赖 opt-out
+func (p *proofs) nodata(name string, qtype uint16) (ok, insecure bool) {
+	lacks := func(bitmap []uint16) bool {
+		return !slices.Contains(bitmap, qtype) && !slices.Contains(bitmap, dns.TypeCNAME)
+	}
+	if n := p.nsecMatch(name); n != nil {
+		return lacks(n.TypeBitMap), false
+	}
+	if n := p.nsecCover(name); n != nil {
+		// 空非终端
+		if dns.IsSubDomain(name, n.NextDomain) {
+			return true, false
+		}
+		// 通配符匹配但没有该类型
+		if w := p.nsecMatch(wildcardOf(nsecEncloser(name, n))); w != nil {
+			return lacks(w.TypeBitMap), false
+		}
+		return false, false
+	}
+	if p.weak() {
+		return true, true
+	}
+	if n := p.nsec3Match(name); n != nil {
+		return lacks(n.TypeBitMap), false
+	}
+	ce, nc, ok := p.closestEncloser(name)
+	if !ok {
+		return false, false
+	}
+	// opt-out 区间内可能存在未签名的委派
+	if qtype == dns.TypeDS && nc.Flags&1 == 1 {
+		return true, true
+	}
+	if w := p.nsec3Match(wildcardOf(ce)); w != nil {
+		return lacks(w.TypeBitMap), false
+	}
+	return false, false
+}
+
+// wildcard 证明通配符展开的应答中查询名不存在，labels 为签名的标签数
+func (p *proofs) wildcard(name string, labels int) bool {
+	if p.nsecCover(name) != nil {
+		return true
+	}
+	return p.nsec3Cover(ancestor(name, labels+1)) != nil
+}
+
+// delegation 由 DS 查询的否定应答判断名称是否为区域分割点
+func (p *proofs) delegation(name string, nxdomain bool) (int, error) {
+	bitmap := func(types []uint16) (int, error) {
+		switch {
+		case slices.Contains(types, dns.TypeDS):
+			return 0, bogus(dns.ExtendedErrorCodeDNSBogus, name, "denial of existence contradicts DS")
+		case slices.Contains(types, dns.TypeNS) && !slices.Contains(types, dns.TypeSOA):
+			return cutInsecure, nil
+		}
+		return cutNone, nil
+	}
+	if n := p.nsecMatch(name); n != nil {
+		return bitmap(n.TypeBitMap)
+	}
+	if n := p.nsecCover(name); n != nil {
+		if nxdomain {
+			return cutNX, nil
+		}
+		return cutNone, nil
+	}
+	if p.weak() {
+		return cutInsecure, nil
+	}
+	if n := p.nsec3Match(name); n != nil {
+		return bitmap(n.TypeBitMap)
+	}
+	if _, nc, ok := p.closestEncloser(name); ok {
+		if nc.Flags&1 == 1 {
+			return cutInsecure, nil
+		}
+		if nxdomain {
+			return cutNX, nil
+		}
+	}
+	return 0, bogus(dns.ExtendedErrorCodeNSECMissing, name, "missing DS denial of existence")
+}
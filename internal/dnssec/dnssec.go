@@ -0,0 +1,230 @@
+package dnssec
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/sync/singleflight"
+)
+
+// DNSSEC 校验配置
+type Options struct {
+	// 是否校验上游应答
+	Enable bool `yaml:"enable"`
+	// 信任锚（DS 或 DNSKEY 记录），为空时使用内置的根信任锚
+	TrustAnchors []string `yaml:"trust-anchors"`
+	// 已校验的 DNSKEY/DS 缓存条目数
+	CacheSize int `yaml:"cache-size" default:"4096"`
+}
+
+// Exchange 查询上游，用于获取信任链
+type Exchange func(req *dns.Msg) (*dns.Msg, error)
+
+// Error 校验失败（bogus），Code 为扩展错误码（RFC 8914）
+type Error struct {
+	Code   uint16
+	Name   string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("dnssec %s: %s", e.Name, e.Reason)
+}
+
+func bogus(code uint16, name, format string, args ...any) *Error {
+	return &Error{Code: code, Name: name, Reason: fmt.Sprintf(format, args...)}
+}
+
+type Validator struct {
+	// 区域 -> 信任锚
+	anchors   map[string][]dns.RR
+	cacheSize int
+
+	access sync.Mutex
+	// 已校验的区域分割点，不同出站的应答可能不同，按出站分别缓存
+	cuts map[cutKey]*cut
+	// 合并同一出站相同区域的信任链查询
+	flight singleflight.Group
+}
+
+func New(options *Options) (*Validator, error) {
+	v := &Validator{
+		anchors:   make(map[string][]dns.RR),
+		cacheSize: max(options.CacheSize, 1),
+		cuts:      make(map[cutKey]*cut),
+	}
+	anchors := options.TrustAnchors
+	if len(anchors) == 0 {
+		anchors = rootAnchors
+	}
+	for _, s := range anchors {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			return nil, fmt.Errorf("dnssec: invalid trust anchor %q: %w", s, err)
+		}
+		switch rr.(type) {
+		case *dns.DS, *dns.DNSKEY:
+		default:
+			return nil, fmt.Errorf("dnssec: trust anchor %q must be DS or DNSKEY", s)
+		}
+		zone := strings.ToLower(rr.Header().Name)
+		v.anchors[zone] = append(v.anchors[zone], rr)
+	}
+	return v, nil
+}
+
+// 单次校验的上下文
+type context struct {
+	v *Validator
+	// 出站标识，exchange 经由该出站查询
+	tag      string
+	exchange Exchange
+	now      time.Time
+}
+
+// 最长 CNAME 链
+const maxChain = 16
+
+// Validate 校验 resp，应答通过校验时返回 true；返回 false 且无错误表示应答不受 DNSSEC 保护（insecure）。
+// resp 需以 DO 标志查询，tag 为 exchange 使用的出站，校验失败时返回 *Error
+func (v *Validator) Validate(req, resp *dns.Msg, tag string, exchange Exchange) (secure bool, err error) {
+	if len(req.Question) == 0 || (resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError) {
+		return false, nil
+	}
+	c := &context{v: v, tag: tag, exchange: exchange, now: time.Now()}
+	q := req.Question[0]
+	secure = true
+
+	// 应答区，通配符展开的记录需要证明查询名不存在
+	answer := groupRRsets(resp.Answer)
+	dnames := make(map[string]*dns.DNAME)
+	wildcards := make(map[string]int)
+	for _, set := range answer {
+		if set.rtype == dns.TypeCNAME && len(set.sigs) == 0 && synthesized(set, dnames) {
+			continue
+		}
+		sig, err := c.verify(set)
+		if err != nil {
+			return false, err
+		}
+		if sig == nil {
+			secure = false
+			continue
+		}
+		if labels := int(sig.Labels); labels < dns.CountLabel(set.name) {
+			wildcards[set.name] = labels
+		}
+		if set.rtype == dns.TypeDNAME {
+			dnames[set.name] = set.rrs[0].(*dns.DNAME)
+		}
+	}
+
+	// 沿 CNAME 链找到最终的查询名
+	sname := strings.ToLower(q.Name)
+	if q.Qtype != dns.TypeCNAME {
+		for range maxChain {
+			set := findRRset(answer, sname, dns.TypeCNAME)
+			if set == nil {
+				break
+			}
+			sname = strings.ToLower(set.rrs[0].(*dns.CNAME).Target)
+		}
+	}
+	negative := resp.Rcode == dns.RcodeNameError ||
+		(q.Qtype != dns.TypeANY && findRRset(answer, sname, q.Qtype) == nil)
+	if !negative && len(wildcards) == 0 {
+		return secure, nil
+	}
+
+	// 授权区中的否定证明
+	var p proofs
+	for _, set := range groupRRsets(resp.Ns) {
+		switch set.rtype {
+		case dns.TypeSOA, dns.TypeNSEC, dns.TypeNSEC3:
+		default:
+			continue
+		}
+		sig, err := c.verify(set)
+		if err != nil {
+			return false, err
+		}
+		if sig == nil {
+			secure = false
+			continue
+		}
+		p.add(set)
+	}
+	for name, labels := range wildcards {
+		if !p.wildcard(name, labels) {
+			return false, bogus(dns.ExtendedErrorCodeNSECMissing, name, "missing wildcard expansion proof")
+		}
+	}
+	if !negative {
+		return secure, nil
+	}
+	if p.empty() {
+		// 区域未签名时允许没有否定证明
+		name := sname
+		if q.Qtype == dns.TypeDS {
+			name = parent(name)
+		}
+		z, err := c.zone(name)
+		if err != nil {
+			return false, err
+		}
+		if z.insecure {
+			return false, nil
+		}
+		return false, bogus(dns.ExtendedErrorCodeNSECMissing, sname, "missing denial of existence")
+	}
+	if !secure {
+		return false, nil
+	}
+	var ok, insecure bool
+	if resp.Rcode == dns.RcodeNameError {
+		ok, insecure = p.nxdomain(sname)
+	} else {
+		ok, insecure = p.nodata(sname, q.Qtype)
+	}
+	if !ok {
+		return false, bogus(dns.ExtendedErrorCodeNSECMissing, sname, "invalid denial of existence")
+	}
+	return !insecure, nil
+}
+
+// synthesized CNAME 由已校验的 DNAME 合成（RFC 6672），本身没有签名
+func synthesized(set *rrset, dnames map[string]*dns.DNAME) bool {
+	target := strings.ToLower(set.rrs[0].(*dns.CNAME).Target)
+	for owner, dname := range dnames {
+		if set.name != owner && dns.IsSubDomain(owner, set.name) {
+			prefix := strings.TrimSuffix(set.name, owner)
+			if target == prefix+strings.ToLower(dns.Fqdn(dname.Target)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Strip 移除客户端未请求的 DNSSEC 记录，qtype 为查询类型
+func Strip(msg *dns.Msg, qtype uint16) {
+	strip := func(section []dns.RR) []dns.RR {
+		rrs := section[:0]
+		for _, rr := range section {
+			switch t := rr.Header().Rrtype; t {
+			case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+				if t != qtype {
+					continue
+				}
+			}
+			rrs = append(rrs, rr)
+		}
+		return rrs
+	}
+	msg.Answer = strip(msg.Answer)
+	msg.Ns = strip(msg.Ns)
+	msg.Extra = strip(msg.Extra)
+}
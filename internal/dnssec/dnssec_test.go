@@ -0,0 +1,525 @@
+package dnssec
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testZone 本地签名的区域，nsec3 为 false 时使用 NSEC 链
+type testZone struct {
+	name   string
+	key    *dns.DNSKEY
+	priv   crypto.Signer
+	nsec3  bool
+	iter   uint16
+	optOut bool
+	// 签名的过期时间
+	expire time.Time
+	rrs    map[string]map[uint16][]dns.RR
+	chain  []dns.RR
+}
+
+// hierarchy 模拟递归服务器，应答来自最接近查询名的区域
+type hierarchy struct {
+	zones   []*testZone
+	queries int
+}
+
+func (h *hierarchy) zone(name string, signed bool) *testZone {
+	z := &testZone{name: name, expire: time.Now().Add(24 * time.Hour), rrs: make(map[string]map[uint16][]dns.RR)}
+	z.add(fmt.Sprintf("%s 300 IN SOA ns.example. hostmaster.example. 1 7200 3600 1209600 300", name))
+	if signed {
+		z.key = &dns.DNSKEY{
+			Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+			Flags:     dns.ZONE | dns.SEP,
+			Protocol:  3,
+			Algorithm: dns.ECDSAP256SHA256,
+		}
+		priv, err := z.key.Generate(256)
+		if err != nil {
+			panic(err)
+		}
+		z.priv = priv.(crypto.Signer)
+		z.addRR(z.key)
+	}
+	h.zones = append(h.zones, z)
+	return z
+}
+
+// delegate 在上级区域添加委派，子区域已签名时同时添加 DS
+func (z *testZone) delegate(child *testZone) {
+	z.add(fmt.Sprintf("%s 3600 IN NS ns.example.", child.name))
+	if child.key != nil {
+		z.addRR(child.key.ToDS(dns.SHA256))
+	}
+}
+
+func (z *testZone) add(s string) {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		panic(err)
+	}
+	z.addRR(rr)
+}
+
+func (z *testZone) addRR(rr dns.RR) {
+	name := strings.ToLower(rr.Header().Name)
+	if z.rrs[name] == nil {
+		z.rrs[name] = make(map[uint16][]dns.RR)
+	}
+	z.rrs[name][rr.Header().Rrtype] = append(z.rrs[name][rr.Header().Rrtype], rr)
+}
+
+// unsignedDelegation name 为不带 DS 的委派
+func (z *testZone) unsignedDelegation(name string) bool {
+	types := z.rrs[name]
+	return name != z.name && types[dns.TypeNS] != nil && types[dns.TypeDS] == nil
+}
+
+// finish 生成 NSEC/NSEC3 链，在添加完记录后调用
+func (z *testZone) finish() {
+	if z.key == nil {
+		return
+	}
+	names := make(map[string]bool)
+	for name := range z.rrs {
+		names[name] = true
+		// NSEC3 链包含空非终端
+		for n := parent(name); z.nsec3 && n != z.name && dns.IsSubDomain(z.name, n); n = parent(n) {
+			names[n] = true
+		}
+	}
+	bitmap := func(name string) []uint16 {
+		var types []uint16
+		for t := range z.rrs[name] {
+			types = append(types, t)
+		}
+		if len(types) > 0 && !z.unsignedDelegation(name) {
+			types = append(types, dns.TypeRRSIG)
+		}
+		return types
+	}
+
+	if !z.nsec3 {
+		sorted := make([]string, 0, len(names))
+		for name := range names {
+			sorted = append(sorted, name)
+		}
+		slices.SortFunc(sorted, canonicalCompare)
+		for i, name := range sorted {
+			types := append(bitmap(name), dns.TypeNSEC, dns.TypeRRSIG)
+			slices.Sort(types)
+			nsec := &dns.NSEC{
+				Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 300},
+				NextDomain: sorted[(i+1)%len(sorted)],
+				TypeBitMap: slices.Compact(types),
+			}
+			z.chain = append(z.chain, nsec)
+			z.addRR(nsec)
+		}
+		return
+	}
+
+	hashes := make(map[string]string)
+	var sorted []string
+	for name := range names {
+		// opt-out 区间不包含未签名的委派
+		if z.optOut && z.unsignedDelegation(name) {
+			continue
+		}
+		hash := dns.HashName(name, dns.SHA1, z.iter, "")
+		hashes[hash] = name
+		sorted = append(sorted, hash)
+	}
+	slices.Sort(sorted)
+	var flags uint8
+	if z.optOut {
+		flags = 1
+	}
+	for i, hash := range sorted {
+		types := bitmap(hashes[hash])
+		slices.Sort(types)
+		nsec3 := &dns.NSEC3{
+			Hdr:        dns.RR_Header{Name: strings.ToLower(hash) + "." + z.name, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 300},
+			Hash:       dns.SHA1,
+			Flags:      flags,
+			Iterations: z.iter,
+			HashLength: 20,
+			NextDomain: sorted[(i+1)%len(sorted)],
+			TypeBitMap: slices.Compact(types),
+		}
+		z.chain = append(z.chain, nsec3)
+	}
+}
+
+func (z *testZone) sign(rrs []dns.RR) *dns.RRSIG {
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Ttl: rrs[0].Header().Ttl},
+		Algorithm:  z.key.Algorithm,
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.name,
+		Inception:  uint32(z.expire.Add(-48 * time.Hour).Unix()),
+		Expiration: uint32(z.expire.Unix()),
+	}
+	if err := sig.Sign(z.priv, rrs); err != nil {
+		panic(err)
+	}
+	return sig
+}
+
+// signed 返回记录集及其签名，委派的 NS 不签名
+func (z *testZone) signed(rrs []dns.RR) []dns.RR {
+	out := slices.Clone(rrs)
+	if z.key != nil && !(rrs[0].Header().Rrtype == dns.TypeNS && strings.ToLower(rrs[0].Header().Name) != z.name) {
+		out = append(out, z.sign(rrs))
+	}
+	return out
+}
+
+// exists 名称存在，包括空非终端
+func (z *testZone) exists(name string) bool {
+	for n := range z.rrs {
+		if dns.IsSubDomain(name, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// encloser 查询名最近的存在的祖先
+func (z *testZone) encloser(name string) string {
+	for n := parent(name); ; n = parent(n) {
+		if n == z.name || z.exists(n) {
+			return n
+		}
+	}
+}
+
+func (z *testZone) nsecCover(name string) dns.RR {
+	for _, rr := range z.chain {
+		n := rr.(*dns.NSEC)
+		owner, next := n.Hdr.Name, n.NextDomain
+		if canonicalCompare(owner, name) < 0 && (canonicalCompare(name, next) < 0 || canonicalCompare(owner, next) >= 0) {
+			return n
+		}
+	}
+	return nil
+}
+
+func (z *testZone) nsec3Match(name string) dns.RR {
+	for _, rr := range z.chain {
+		if rr.(*dns.NSEC3).Match(name) {
+			return rr
+		}
+	}
+	return nil
+}
+
+func (z *testZone) nsec3Cover(name string) dns.RR {
+	for _, rr := range z.chain {
+		if n := rr.(*dns.NSEC3); n.Cover(name) && !n.Match(name) {
+			return rr
+		}
+	}
+	return nil
+}
+
+// closestEncloser NSEC3 最近祖先证明：匹配最近祖先与覆盖下一级名称的记录
+func (z *testZone) closestEncloser(name string) (string, []dns.RR) {
+	next := name
+	for ce := parent(name); ; next, ce = ce, parent(ce) {
+		if match := z.nsec3Match(ce); match != nil {
+			return ce, []dns.RR{match, z.nsec3Cover(next)}
+		}
+	}
+}
+
+// answer 生成 name/qtype 的应答，包括通配符展开与否定证明
+func (z *testZone) answer(resp *dns.Msg, name string, qtype uint16) {
+	if rrs := z.rrs[name][qtype]; rrs != nil {
+		resp.Answer = z.signed(rrs)
+		return
+	}
+	var proof []dns.RR
+	defer func() {
+		resp.Ns = z.signed(z.rrs[z.name][dns.TypeSOA])
+		// 同一条记录只添加一次
+		for _, rr := range proof {
+			if rr == nil || slices.ContainsFunc(resp.Ns, func(o dns.RR) bool { return dns.IsDuplicate(o, rr) }) {
+				continue
+			}
+			resp.Ns = append(resp.Ns, z.signed([]dns.RR{rr})...)
+		}
+		if z.key == nil {
+			resp.Ns = resp.Ns[:1]
+		}
+	}()
+
+	if z.exists(name) {
+		if !z.nsec3 {
+			if nsec := z.rrs[name][dns.TypeNSEC]; nsec != nil {
+				proof = append(proof, nsec[0])
+			} else {
+				proof = append(proof, z.nsecCover(name))
+			}
+		} else if match := z.nsec3Match(name); match != nil {
+			proof = append(proof, match)
+		} else {
+			_, proof = z.closestEncloser(name)
+		}
+		return
+	}
+
+	ce := z.encloser(name)
+	wildcard := wildcardOf(ce)
+	if !z.nsec3 {
+		proof = append(proof, z.nsecCover(name))
+	} else {
+		ce, proof = z.closestEncloser(name)
+		wildcard = wildcardOf(ce)
+	}
+	if rrs := z.rrs[wildcard][qtype]; rrs != nil {
+		var sig *dns.RRSIG
+		if z.key != nil {
+			sig = z.sign(rrs)
+		}
+		for _, rr := range rrs {
+			rr = dns.Copy(rr)
+			rr.Header().Name = name
+			resp.Answer = append(resp.Answer, rr)
+		}
+		if sig != nil {
+			sig.Hdr.Name = name
+			resp.Answer = append(resp.Answer, sig)
+		}
+		// 通配符展开只需证明查询名不存在
+		if z.nsec3 {
+			proof = proof[1:]
+		}
+		return
+	}
+	if z.rrs[wildcard] != nil {
+		// 通配符存在但没有该类型
+		if !z.nsec3 {
+			proof = append(proof, z.rrs[wildcard][dns.TypeNSEC][0])
+		} else {
+			proof = append(proof, z.nsec3Match(wildcard))
+		}
+		return
+	}
+	resp.Rcode = dns.RcodeNameError
+	if !z.nsec3 {
+		proof = append(proof, z.nsecCover(wildcard))
+	} else {
+		proof = append(proof, z.nsec3Cover(wildcard))
+	}
+}
+
+func (h *hierarchy) exchange(req *dns.Msg) (*dns.Msg, error) {
+	h.queries++
+	q := req.Question[0]
+	name := strings.ToLower(q.Name)
+	// DS 由上级区域应答
+	var best *testZone
+	for _, z := range h.zones {
+		if !dns.IsSubDomain(z.name, name) || (q.Qtype == dns.TypeDS && z.name == name && name != ".") {
+			continue
+		}
+		if best == nil || dns.CountLabel(z.name) > dns.CountLabel(best.name) {
+			best = z
+		}
+	}
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.SetEdns0(dns.DefaultMsgSize, true)
+	best.answer(resp, name, q.Qtype)
+	return resp, nil
+}
+
+// newHierarchy 根区域下的测试区域：
+//
+//	secure.test.   NSEC 签名
+//	insecure.test. 未签名的委派
+//	expired.test.  签名已过期
+//	nsec3.test.    NSEC3 签名
+//	optout.test.   NSEC3 opt-out，sub.optout.test. 为未签名的委派
+//	weak.test.     NSEC3 迭代次数超过上限
+func newHierarchy() (*hierarchy, *Validator) {
+	h := &hierarchy{}
+	root := h.zone(".", true)
+	tld := h.zone("test.", true)
+	root.delegate(tld)
+
+	secure := h.zone("secure.test.", true)
+	secure.add("www.secure.test. 300 IN A 192.0.2.1")
+	secure.add("*.wild.secure.test. 300 IN A 192.0.2.2")
+
+	insecure := h.zone("insecure.test.", false)
+	insecure.add("www.insecure.test. 300 IN A 192.0.2.3")
+
+	expired := h.zone("expired.test.", true)
+	expired.expire = time.Now().Add(-time.Hour)
+	expired.add("www.expired.test. 300 IN A 192.0.2.4")
+
+	nsec3 := h.zone("nsec3.test.", true)
+	nsec3.nsec3 = true
+	nsec3.add("www.nsec3.test. 300 IN A 192.0.2.5")
+	nsec3.add("*.wild.nsec3.test. 300 IN A 192.0.2.6")
+
+	optOut := h.zone("optout.test.", true)
+	optOut.nsec3, optOut.optOut = true, true
+	optOut.add("www.optout.test. 300 IN A 192.0.2.7")
+	sub := h.zone("sub.optout.test.", false)
+	sub.add("www.sub.optout.test. 300 IN A 192.0.2.8")
+	optOut.delegate(sub)
+
+	weak := h.zone("weak.test.", true)
+	weak.nsec3, weak.iter = true, maxIterations+1
+	weak.add("www.weak.test. 300 IN A 192.0.2.9")
+
+	for _, child := range []*testZone{secure, insecure, expired, nsec3, optOut, weak} {
+		tld.delegate(child)
+	}
+	for _, z := range h.zones {
+		z.finish()
+	}
+
+	v, err := New(&Options{TrustAnchors: []string{root.key.ToDS(dns.SHA256).String()}, CacheSize: 64})
+	if err != nil {
+		panic(err)
+	}
+	return h, v
+}
+
+// removeType 移除区中指定类型的记录，RRSIG 按覆盖的类型匹配
+func removeType(section []dns.RR, rtype uint16) []dns.RR {
+	return slices.DeleteFunc(section, func(rr dns.RR) bool {
+		if sig, ok := rr.(*dns.RRSIG); ok && rtype != dns.TypeRRSIG {
+			return sig.TypeCovered == rtype
+		}
+		return rr.Header().Rrtype == rtype
+	})
+}
+
+func TestValidate(t *testing.T) {
+	h, v := newHierarchy()
+	tests := []struct {
+		name   string
+		qname  string
+		qtype  uint16
+		modify func(*dns.Msg)
+		rcode  int
+		secure bool
+		// 期望的扩展错误码，为 0 时期望校验通过
+		code uint16
+	}{
+		{name: "secure", qname: "www.secure.test.", qtype: dns.TypeA, secure: true},
+		{name: "insecure delegation", qname: "www.insecure.test.", qtype: dns.TypeA},
+		{
+			name: "bad signature", qname: "www.secure.test.", qtype: dns.TypeA,
+			modify: func(m *dns.Msg) { m.Answer[0].(*dns.A).A[3] = 99 },
+			code:   dns.ExtendedErrorCodeDNSBogus,
+		},
+		{name: "expired signature", qname: "www.expired.test.", qtype: dns.TypeA, code: dns.ExtendedErrorCodeSignatureExpired},
+		{
+			name: "missing rrsig", qname: "www.secure.test.", qtype: dns.TypeA,
+			modify: func(m *dns.Msg) { m.Answer = removeType(m.Answer, dns.TypeRRSIG) },
+			code:   dns.ExtendedErrorCodeRRSIGsMissing,
+		},
+		{name: "nsec nxdomain", qname: "nx.secure.test.", qtype: dns.TypeA, rcode: dns.RcodeNameError, secure: true},
+		{name: "nsec nodata", qname: "www.secure.test.", qtype: dns.TypeTXT, secure: true},
+		{
+			name: "nsec nxdomain without proof", qname: "nx.secure.test.", qtype: dns.TypeA, rcode: dns.RcodeNameError,
+			modify: func(m *dns.Msg) { m.Ns = removeType(m.Ns, dns.TypeNSEC) },
+			code:   dns.ExtendedErrorCodeNSECMissing,
+		},
+		{name: "nsec3 nxdomain", qname: "nx.nsec3.test.", qtype: dns.TypeA, rcode: dns.RcodeNameError, secure: true},
+		{name: "nsec3 nodata", qname: "www.nsec3.test.", qtype: dns.TypeTXT, secure: true},
+		{
+			name: "nsec3 nodata without proof", qname: "www.nsec3.test.", qtype: dns.TypeTXT,
+			modify: func(m *dns.Msg) { m.Ns = removeType(m.Ns, dns.TypeNSEC3) },
+			code:   dns.ExtendedErrorCodeNSECMissing,
+		},
+		{name: "nsec wildcard", qname: "a.wild.secure.test.", qtype: dns.TypeA, secure: true},
+		{name: "nsec3 wildcard", qname: "a.wild.nsec3.test.", qtype: dns.TypeA, secure: true},
+		{
+			name: "wildcard without proof", qname: "a.wild.secure.test.", qtype: dns.TypeA,
+			modify: func(m *dns.Msg) { m.Ns = nil },
+			code:   dns.ExtendedErrorCodeNSECMissing,
+		},
+		{name: "nsec wildcard nodata", qname: "a.wild.secure.test.", qtype: dns.TypeTXT, secure: true},
+		{name: "opt-out unsigned delegation", qname: "www.sub.optout.test.", qtype: dns.TypeA},
+		{name: "opt-out nxdomain", qname: "nx.optout.test.", qtype: dns.TypeA, rcode: dns.RcodeNameError},
+		{name: "opt-out secure answer", qname: "www.optout.test.", qtype: dns.TypeA, secure: true},
+		{name: "iteration cap nxdomain", qname: "nx.weak.test.", qtype: dns.TypeA, rcode: dns.RcodeNameError},
+		{name: "iteration cap nodata", qname: "www.weak.test.", qtype: dns.TypeTXT},
+		{name: "iteration cap answer", qname: "www.weak.test.", qtype: dns.TypeA, secure: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := new(dns.Msg)
+			req.SetQuestion(tt.qname, tt.qtype)
+			req.SetEdns0(dns.DefaultMsgSize, true)
+			resp, _ := h.exchange(req)
+			if resp.Rcode != tt.rcode {
+				t.Fatalf("rcode = %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if tt.modify != nil {
+				tt.modify(resp)
+			}
+			secure, err := v.Validate(req, resp, "test", h.exchange)
+			if tt.code != 0 {
+				var e *Error
+				if !errors.As(err, &e) {
+					t.Fatalf("err = %v, want code %d", err, tt.code)
+				}
+				if e.Code != tt.code {
+					t.Fatalf("code = %d (%v), want %d", e.Code, e, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if secure != tt.secure {
+				t.Fatalf("secure = %v, want %v", secure, tt.secure)
+			}
+		})
+	}
+}
+
+// 信任链按出站分别缓存，另一出站不能复用已校验的结果
+func TestValidateCachePerOutbound(t *testing.T) {
+	h, v := newHierarchy()
+	req := new(dns.Msg)
+	req.SetQuestion("www.secure.test.", dns.TypeA)
+	req.SetEdns0(dns.DefaultMsgSize, true)
+	resp, _ := h.exchange(req)
+
+	if secure, err := v.Validate(req, resp, "a", h.exchange); !secure || err != nil {
+		t.Fatalf("outbound a: secure = %v, err = %v", secure, err)
+	}
+	queries := h.queries
+	if secure, err := v.Validate(req, resp, "a", h.exchange); !secure || err != nil {
+		t.Fatalf("outbound a cached: secure = %v, err = %v", secure, err)
+	}
+	if h.queries != queries {
+		t.Fatalf("outbound a cached: %d chain queries, want 0", h.queries-queries)
+	}
+
+	failing := func(req *dns.Msg) (*dns.Msg, error) {
+		return nil, errors.New("unreachable")
+	}
+	_, err := v.Validate(req, resp, "b", failing)
+	var e *Error
+	if !errors.As(err, &e) || e.Code != dns.ExtendedErrorCodeDNSSECIndeterminate {
+		t.Fatalf("outbound b: err = %v, want indeterminate", err)
+	}
+}
@@ -0,0 +1,266 @@
+package filter
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/taodev/godns/internal/utils"
+	"github.com/taodev/pkg/defaults"
+)
+
+// 拦截方式
+const (
+	BlockModeNXDOMAIN = "nxdomain"
+	BlockModeNullIP   = "null-ip"
+	BlockModeRefused  = "refused"
+	BlockModeNODATA   = "nodata"
+)
+
+// 拦截配置
+type Options struct {
+	// 规则文件，支持 hosts、域名列表与 AdBlock 格式
+	Lists []string `yaml:"lists"`
+	// 自定义规则，格式同规则文件
+	Rules []string `yaml:"rules"`
+	// 拦截方式（nxdomain/null-ip/refused/nodata）
+	BlockMode string `yaml:"block-mode" default:"nxdomain"`
+	// 拦截应答的 TTL（null-ip 的记录与 nxdomain、nodata 应答的 SOA）
+	TTL time.Duration `yaml:"ttl" default:"60s"`
+	// 规则文件检查间隔，文件修改后重新加载，0 表示不检查
+	ReloadInterval time.Duration `yaml:"reload-interval" default:"5m"`
+}
+
+// ListInfo 规则列表状态
+type ListInfo struct {
+	File string `json:"file"`
+	ListStats
+	UpdatedAt time.Time `json:"updated_at"`
+	LastErr   string    `json:"last_error,omitempty"`
+}
+
+type list struct {
+	info    ListInfo
+	modTime time.Time
+	rules   *ruleSet
+}
+
+type Filter struct {
+	options Options
+	rules   atomic.Pointer[ruleSet]
+
+	access sync.Mutex
+	custom *ruleSet
+	lists  []*list
+
+	closeCh chan struct{}
+	wait    sync.WaitGroup
+}
+
+// New 加载所有规则，任一规则文件读取失败时返回错误
+func New(options Options) (*Filter, error) {
+	if err := defaults.Set(&options); err != nil {
+		return nil, err
+	}
+	switch options.BlockMode {
+	case BlockModeNXDOMAIN, BlockModeNullIP, BlockModeRefused, BlockModeNODATA:
+	default:
+		return nil, fmt.Errorf("unknown block mode: %s", options.BlockMode)
+	}
+	f := &Filter{
+		options: options,
+		custom:  newRuleSet(),
+		closeCh: make(chan struct{}),
+	}
+	stats, err := f.custom.parse(strings.NewReader(strings.Join(options.Rules, "\n")))
+	if err != nil {
+		return nil, err
+	}
+	if stats.Invalid > 0 {
+		return nil, fmt.Errorf("%d invalid filter rules", stats.Invalid)
+	}
+	for _, file := range options.Lists {
+		l := &list{info: ListInfo{File: file}}
+		if err := l.load(); err != nil {
+			return nil, err
+		}
+		f.lists = append(f.lists, l)
+	}
+	f.rebuild()
+	return f, nil
+}
+
+func (f *Filter) Start() error {
+	if f.options.ReloadInterval > 0 && len(f.lists) > 0 {
+		f.wait.Add(1)
+		go f.reloadLoop()
+	}
+	return nil
+}
+
+func (f *Filter) Close() {
+	close(f.closeCh)
+	f.wait.Wait()
+}
+
+// Match 域名是否被拦截
+func (f *Filter) Match(domain string) bool {
+	return f.rules.Load().match(strings.ToLower(strings.TrimSuffix(domain, ".")))
+}
+
+// Block 被拦截时返回拦截应答
+func (f *Filter) Block(req *dns.Msg) (*dns.Msg, bool) {
+	q := req.Question[0]
+	if !f.Match(q.Name) {
+		return nil, false
+	}
+	var resp *dns.Msg
+	switch f.options.BlockMode {
+	case BlockModeRefused:
+		resp = new(dns.Msg)
+		resp.SetRcode(req, dns.RcodeRefused)
+	case BlockModeNODATA:
+		resp = utils.NewMsgNODATA(req)
+	case BlockModeNullIP:
+		resp = f.nullIP(req)
+	default:
+		resp = utils.NewMsgNXDOMAIN(req)
+	}
+	resp.RecursionAvailable = true
+	if resp.Rcode == dns.RcodeNameError || (resp.Rcode == dns.RcodeSuccess && len(resp.Answer) == 0) {
+		// 否定应答携带 SOA，客户端按 TTL 缓存（RFC 2308），否则每次都会重新查询
+		resp.Ns = append(resp.Ns, f.soa(q.Name))
+	}
+	return resp, true
+}
+
+// soa 拦截应答使用的合成 SOA，TTL 与 MINIMUM 均为拦截应答的 TTL
+func (f *Filter) soa(name string) dns.RR {
+	ttl := uint32(f.options.TTL.Seconds())
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: ttl},
+		Ns:      "localhost.",
+		Mbox:    "nobody.invalid.",
+		Serial:  1,
+		Refresh: 1800,
+		Retry:   900,
+		Expire:  604800,
+		Minttl:  ttl,
+	}
+}
+
+// nullIP A 返回 0.0.0.0，AAAA 返回 ::，其余类型返回 NODATA
+func (f *Filter) nullIP(req *dns.Msg) *dns.Msg {
+	q := req.Question[0]
+	hdr := dns.RR_Header{
+		Name:   q.Name,
+		Rrtype: q.Qtype,
+		Class:  dns.ClassINET,
+		Ttl:    uint32(f.options.TTL.Seconds()),
+	}
+	var rr dns.RR
+	switch q.Qtype {
+	case dns.TypeA:
+		rr = &dns.A{Hdr: hdr, A: net.IPv4zero}
+	case dns.TypeAAAA:
+		rr = &dns.AAAA{Hdr: hdr, AAAA: net.IPv6zero}
+	default:
+		return utils.NewMsgNODATA(req)
+	}
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Answer = append(resp.Answer, rr)
+	return resp
+}
+
+// Lists 规则列表状态
+func (f *Filter) Lists() []ListInfo {
+	f.access.Lock()
+	defer f.access.Unlock()
+	infos := make([]ListInfo, 0, len(f.lists))
+	for _, l := range f.lists {
+		infos = append(infos, l.info)
+	}
+	return infos
+}
+
+// rebuild 合并所有列表，原子替换生效的规则
+func (f *Filter) rebuild() {
+	rules := newRuleSet()
+	rules.merge(f.custom)
+	for _, l := range f.lists {
+		rules.merge(l.rules)
+	}
+	f.rules.Store(rules)
+}
+
+func (f *Filter) reloadLoop() {
+	defer f.wait.Done()
+	ticker := time.NewTicker(f.options.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.closeCh:
+			return
+		case <-ticker.C:
+			f.reload()
+		}
+	}
+}
+
+// reload 重新加载修改过的规则文件，读取失败时保留旧规则
+func (f *Filter) reload() {
+	f.access.Lock()
+	defer f.access.Unlock()
+	changed := false
+	for _, l := range f.lists {
+		info, err := os.Stat(l.info.File)
+		if err != nil {
+			l.info.LastErr = err.Error()
+			slog.Error("stat filter list failed", "file", l.info.File, "err", err)
+			continue
+		}
+		if info.ModTime().Equal(l.modTime) {
+			continue
+		}
+		if err = l.load(); err != nil {
+			l.info.LastErr = err.Error()
+			slog.Error("reload filter list failed", "file", l.info.File, "err", err)
+			continue
+		}
+		changed = true
+	}
+	if changed {
+		f.rebuild()
+	}
+}
+
+func (l *list) load() error {
+	file, err := os.Open(l.info.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	rules := newRuleSet()
+	stats, err := rules.parse(file)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", l.info.File, err)
+	}
+	l.rules = rules
+	l.modTime = info.ModTime()
+	l.info.ListStats = stats
+	l.info.UpdatedAt = time.Now()
+	l.info.LastErr = ""
+	slog.Info("filter list loaded", "file", l.info.File, "rules", stats.Rules, "allow", stats.Allow, "invalid", stats.Invalid)
+	return nil
+}
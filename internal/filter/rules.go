@@ -0,0 +1,188 @@
+package filter
+
+import (
+	"bufio"
+	"io"
+	"maps"
+	"net/netip"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// hosts 文件中不作为拦截规则的主机名
+var hostsIgnored = map[string]struct{}{
+	"localhost":             {},
+	"localhost.localdomain": {},
+	"local":                 {},
+	"broadcasthost":         {},
+	"ip6-localhost":         {},
+	"ip6-loopback":          {},
+	"ip6-localnet":          {},
+	"ip6-mcastprefix":       {},
+	"ip6-allnodes":          {},
+	"ip6-allrouters":        {},
+	"ip6-allhosts":          {},
+	"0.0.0.0":               {},
+}
+
+// ruleSet 拦截规则集合，exact 只匹配域名本身，suffix 与 allow 匹配域名及其子域名
+type ruleSet struct {
+	exact  map[string]struct{}
+	suffix map[string]struct{}
+	allow  map[string]struct{}
+}
+
+func newRuleSet() *ruleSet {
+	return &ruleSet{
+		exact:  make(map[string]struct{}),
+		suffix: make(map[string]struct{}),
+		allow:  make(map[string]struct{}),
+	}
+}
+
+// match 判断域名是否被拦截，白名单优先；domain 为小写且不带末尾的点
+func (s *ruleSet) match(domain string) bool {
+	if matchSuffix(domain, s.allow) {
+		return false
+	}
+	if _, ok := s.exact[domain]; ok {
+		return true
+	}
+	return matchSuffix(domain, s.suffix)
+}
+
+func matchSuffix(domain string, suffix map[string]struct{}) bool {
+	for {
+		if _, ok := suffix[domain]; ok {
+			return true
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			return false
+		}
+		domain = domain[i+1:]
+	}
+}
+
+// ListStats 规则列表统计
+type ListStats struct {
+	// 拦截规则数
+	Rules int `json:"rules"`
+	// 白名单规则数
+	Allow int `json:"allow"`
+	// 无法识别的行数
+	Invalid int `json:"invalid"`
+}
+
+// merge 合并规则
+func (s *ruleSet) merge(other *ruleSet) {
+	maps.Copy(s.exact, other.exact)
+	maps.Copy(s.suffix, other.suffix)
+	maps.Copy(s.allow, other.allow)
+}
+
+// parse 解析规则，每行按以下格式之一识别：
+//
+//	0.0.0.0 ads.example.com        hosts 格式，只拦截该域名
+//	ads.example.com                域名列表，拦截该域名及子域名
+//	||ads.example.com^             AdBlock 格式，拦截该域名及子域名
+//	@@||cdn.example.com^           AdBlock 白名单
+//
+// 以 #、! 开头的行及 [Adblock Plus] 等文件头视为注释
+func (s *ruleSet) parse(r io.Reader) (stats ListStats, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == '!' || line[0] == '[' {
+			continue
+		}
+		if !s.parseLine(line, &stats) {
+			stats.Invalid++
+		}
+	}
+	return stats, scanner.Err()
+}
+
+func (s *ruleSet) parseLine(line string, stats *ListStats) bool {
+	// AdBlock 语法
+	if rule, ok := strings.CutPrefix(line, "@@"); ok {
+		domain, ok := parseAdBlock(rule)
+		if !ok {
+			return false
+		}
+		s.allow[domain] = struct{}{}
+		stats.Allow++
+		return true
+	}
+	if strings.HasPrefix(line, "||") {
+		domain, ok := parseAdBlock(line)
+		if !ok {
+			return false
+		}
+		s.suffix[domain] = struct{}{}
+		stats.Rules++
+		return true
+	}
+
+	// 去掉行尾注释
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = strings.TrimSpace(line[:i])
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return true
+	}
+	// hosts 格式
+	if _, err := netip.ParseAddr(fields[0]); err == nil {
+		if len(fields) < 2 {
+			return false
+		}
+		for _, host := range fields[1:] {
+			host = strings.ToLower(host)
+			if _, ignored := hostsIgnored[host]; ignored {
+				continue
+			}
+			domain, ok := normalize(host)
+			if !ok {
+				return false
+			}
+			s.exact[domain] = struct{}{}
+			stats.Rules++
+		}
+		return true
+	}
+	// 域名列表
+	if len(fields) != 1 {
+		return false
+	}
+	domain, ok := normalize(fields[0])
+	if !ok {
+		return false
+	}
+	s.suffix[domain] = struct{}{}
+	stats.Rules++
+	return true
+}
+
+// parseAdBlock 解析 ||domain^ 规则，带修饰符（$）的规则不支持
+func parseAdBlock(rule string) (string, bool) {
+	rule, ok := strings.CutPrefix(rule, "||")
+	if !ok || strings.ContainsAny(rule, "$*/") {
+		return "", false
+	}
+	rule = strings.TrimSuffix(rule, "^")
+	return normalize(rule)
+}
+
+// normalize 转为小写并去掉末尾的点
+func normalize(domain string) (string, bool) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if domain == "" {
+		return "", false
+	}
+	if _, ok := dns.IsDomainName(domain); !ok || strings.ContainsAny(domain, "^|@ ") {
+		return "", false
+	}
+	return domain, true
+}
@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "godns"
+
+// 缓存查询结果
+const (
+	CacheHit     = "hit"
+	CacheMiss    = "miss"
+	CacheRefresh = "refresh"
+	CacheStale   = "stale"
+)
+
+var (
+	queries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "queries_total",
+		Help:      "DNS queries by inbound, qtype, rcode and outbound.",
+	}, []string{"inbound", "qtype", "rcode", "outbound"})
+
+	upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_duration_seconds",
+		Help:      "Upstream exchange round-trip time.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"outbound"})
+
+	upstreamErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_errors_total",
+		Help:      "Failed upstream exchanges, including SERVFAIL responses.",
+	}, []string{"outbound"})
+
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Cache lookups by result (hit, miss, stale) and prefetches (refresh).",
+	}, []string{"result"})
+
+	prefetchDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_prefetch_dropped_total",
+		Help:      "Prefetches dropped because the refresh queue was full.",
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_entries",
+		Help:      "Entries in the cache.",
+	}, func() float64 { return float64(loadCacheStats().Entries) })
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_bytes",
+		Help:      "Packed size of cached responses in bytes.",
+	}, func() float64 { return float64(loadCacheStats().Bytes) })
+
+	_ = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_evictions_total",
+		Help:      "Entries removed from the cache, including expired and deleted entries.",
+	}, func() float64 { return float64(loadCacheStats().Evicted) })
+
+	rewriteHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rewrite_hits_total",
+		Help:      "Queries answered by rewrite rules.",
+	})
+
+	connections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "inbound_connections",
+		Help:      "In-flight TCP connections per inbound.",
+	}, []string{"inbound"})
+)
+
+// Handler Prometheus 指标接口
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveQuery 统计一次查询，resp 为空时视为 SERVFAIL
+func ObserveQuery(inbound string, qtype uint16, resp *dns.Msg, outbound string) {
+	rcode := dns.RcodeServerFailure
+	if resp != nil {
+		rcode = resp.Rcode
+	}
+	queries.WithLabelValues(inbound, dns.TypeToString[qtype], dns.RcodeToString[rcode], outbound).Inc()
+}
+
+// ObserveUpstream 统计一次上游请求
+func ObserveUpstream(outbound string, rtt time.Duration, err error) {
+	if err != nil {
+		upstreamErrors.WithLabelValues(outbound).Inc()
+		return
+	}
+	upstreamDuration.WithLabelValues(outbound).Observe(rtt.Seconds())
+}
+
+// ObserveCache 统计一次缓存查询
+func ObserveCache(result string) {
+	cacheRequests.WithLabelValues(result).Inc()
+}
+
+// ObservePrefetchDropped 统计一次因刷新队列已满丢弃的预取
+func ObservePrefetchDropped() {
+	prefetchDropped.Inc()
+}
+
+// CacheStats 缓存占用统计
+type CacheStats struct {
+	Entries int
+	Bytes   int64
+	Evicted uint64
+}
+
+var cacheStats atomic.Pointer[func() CacheStats]
+
+// SetCacheStats 设置缓存占用统计的来源，采集指标时调用
+func SetCacheStats(fn func() CacheStats) {
+	cacheStats.Store(&fn)
+}
+
+func loadCacheStats() CacheStats {
+	if fn := cacheStats.Load(); fn != nil {
+		return (*fn)()
+	}
+	return CacheStats{}
+}
+
+// ObserveRewrite 统计一次重写命中
+func ObserveRewrite() {
+	rewriteHits.Inc()
+}
+
+// ConnOpened 入站连接建立
+func ConnOpened(inbound string) {
+	connections.WithLabelValues(inbound).Inc()
+}
+
+// ConnClosed 入站连接关闭
+func ConnClosed(inbound string) {
+	connections.WithLabelValues(inbound).Dec()
+}
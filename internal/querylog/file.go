@@ -0,0 +1,205 @@
+package querylog
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	backupTimeFormat = "20060102-150405.000"
+	// 轮转失败后继续写入当前文件，间隔一段时间再重试
+	rotateRetryInterval = time.Minute
+)
+
+// WriterSink 以 JSON Lines 写入 io.Writer
+type WriterSink struct {
+	access  sync.Mutex
+	encoder *json.Encoder
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{encoder: json.NewEncoder(w)}
+}
+
+func (s *WriterSink) Write(entry *Entry) error {
+	s.access.Lock()
+	defer s.access.Unlock()
+	return s.encoder.Encode(entry)
+}
+
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+// FileSink 以 JSON Lines 写入文件，按大小与时间轮转
+type FileSink struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	access   sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	// 轮转失败后的下次重试时间
+	retryAt time.Time
+}
+
+func NewFileSink(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*FileSink, error) {
+	s := &FileSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) Write(entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	s.access.Lock()
+	defer s.access.Unlock()
+	if s.needRotate(int64(len(data))) {
+		if err = s.rotate(); err != nil {
+			// 旧文件仍然可用，本次继续写入
+			s.retryAt = time.Now().Add(rotateRetryInterval)
+			slog.Warn("rotate query log failed", "file", s.path, "err", err)
+		}
+	}
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	return err
+}
+
+func (s *FileSink) Close() error {
+	s.access.Lock()
+	defer s.access.Unlock()
+	return s.file.Close()
+}
+
+func (s *FileSink) open() error {
+	file, info, err := openFile(s.path)
+	if err != nil {
+		return err
+	}
+	s.setFile(file, info)
+	return nil
+}
+
+func openFile(path string) (*os.File, os.FileInfo, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+func (s *FileSink) setFile(file *os.File, info os.FileInfo) {
+	s.file = file
+	s.size = info.Size()
+	s.openedAt = info.ModTime()
+	if s.size == 0 {
+		s.openedAt = time.Now()
+	}
+}
+
+func (s *FileSink) needRotate(n int64) bool {
+	if s.size == 0 || time.Now().Before(s.retryAt) {
+		return false
+	}
+	if s.maxSize > 0 && s.size+n > s.maxSize {
+		return true
+	}
+	return s.maxAge > 0 && time.Since(s.openedAt) >= s.maxAge
+}
+
+// rotate 将当前文件重命名为带时间后缀的备份，并清理多余的备份；
+// 新文件打开前保留旧文件句柄，失败时恢复原文件名并继续写入旧文件
+func (s *FileSink) rotate() error {
+	backup := s.path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(s.path, backup); err != nil {
+		// 部分系统（如 Windows）不能重命名已打开的文件，关闭后重试
+		return s.rotateClosed(backup)
+	}
+	file, info, err := openFile(s.path)
+	if err != nil {
+		if renameErr := os.Rename(backup, s.path); renameErr != nil {
+			slog.Warn("restore query log failed", "file", backup, "err", renameErr)
+		}
+		return err
+	}
+	if err := s.file.Close(); err != nil {
+		slog.Warn("close query log failed", "file", backup, "err", err)
+	}
+	s.setFile(file, info)
+	s.cleanBackups()
+	return nil
+}
+
+// rotateClosed 关闭当前文件后轮转，失败时重新打开原文件
+func (s *FileSink) rotateClosed(backup string) error {
+	s.file.Close()
+	err := os.Rename(s.path, backup)
+	if err == nil {
+		if err = s.open(); err == nil {
+			s.cleanBackups()
+			return nil
+		}
+		if renameErr := os.Rename(backup, s.path); renameErr != nil {
+			slog.Warn("restore query log failed", "file", backup, "err", renameErr)
+		}
+	}
+	if openErr := s.open(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	return err
+}
+
+func (s *FileSink) cleanBackups() {
+	if s.maxBackups <= 0 {
+		return
+	}
+	backups, err := filepath.Glob(s.path + ".*")
+	if err != nil {
+		return
+	}
+	backups = slices.DeleteFunc(backups, func(name string) bool {
+		_, err := time.Parse(backupTimeFormat, strings.TrimPrefix(name, s.path+"."))
+		return err != nil
+	})
+	if len(backups) <= s.maxBackups {
+		return
+	}
+	// 时间后缀按字典序即为时间顺序
+	slices.Sort(backups)
+	for _, name := range backups[:len(backups)-s.maxBackups] {
+		if err := os.Remove(name); err != nil {
+			slog.Warn("remove query log backup failed", "file", name, "err", err)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package querylog
+
+import (
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 查询日志配置
+type Options struct {
+	// 日志文件路径，为空时不写文件
+	File string `yaml:"file"`
+	// 单个文件最大大小（MB），超过后轮转
+	MaxSize int64 `yaml:"max-size" default:"100"`
+	// 单个文件最长使用时间，超过后轮转，0 表示不按时间轮转
+	MaxAge time.Duration `yaml:"max-age" default:"24h"`
+	// 保留的历史文件数，0 表示全部保留
+	MaxBackups int `yaml:"max-backups" default:"7"`
+	// 同时输出到标准输出
+	Stdout bool `yaml:"stdout"`
+	// 采样率（0~1），1 表示记录全部查询
+	SampleRate float64 `yaml:"sample-rate" default:"1"`
+	// 内存中保留的最近查询条数，供管理接口读取，0 表示不保留
+	BufferSize int `yaml:"buffer-size" default:"1000"`
+	// 异步写入队列长度，队列满时丢弃
+	QueueSize int `yaml:"queue-size" default:"1024"`
+}
+
+// 查询来源
+const (
+	SourceUpstream = "upstream"
+	SourceCache    = "cache"
+	SourceStale    = "stale"
+	SourceRewrite  = "rewrite"
+	SourceZone     = "zone"
+	SourceBlock    = "block"
+	SourceReject   = "reject"
+)
+
+// Entry 一条查询日志
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Client   string    `json:"client"`
+	Inbound  string    `json:"inbound"`
+	Policy   string    `json:"policy,omitempty"`
+	Name     string    `json:"qname"`
+	Type     string    `json:"qtype"`
+	Source   string    `json:"source"`
+	Outbound string    `json:"outbound,omitempty"`
+	Rcode    string    `json:"rcode"`
+	Answers  []string  `json:"answers,omitempty"`
+	// 处理耗时（毫秒）
+	Elapsed float64 `json:"elapsed_ms"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// Sink 查询日志输出
+type Sink interface {
+	Write(entry *Entry) error
+	Close() error
+}
+
+// QueryLog 查询日志，nil 时所有方法为空操作
+type QueryLog struct {
+	options *Options
+	ring    *Ring
+	sinks   []Sink
+	queue   chan *Entry
+	wait    sync.WaitGroup
+
+	// 关闭后入站处理中的查询仍可能调用 Log，写入队列前检查
+	access sync.RWMutex
+	closed bool
+}
+
+func New(options *Options) (*QueryLog, error) {
+	l := &QueryLog{
+		options: options,
+		queue:   make(chan *Entry, max(options.QueueSize, 1)),
+	}
+	if options.BufferSize > 0 {
+		l.ring = NewRing(options.BufferSize)
+	}
+	if options.File != "" {
+		file, err := NewFileSink(options.File, options.MaxSize<<20, options.MaxAge, options.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		l.sinks = append(l.sinks, file)
+	}
+	if options.Stdout {
+		l.sinks = append(l.sinks, NewWriterSink(os.Stdout))
+	}
+	return l, nil
+}
+
+// AddSink 添加输出，需在 Start 前调用
+func (l *QueryLog) AddSink(sink Sink) {
+	l.sinks = append(l.sinks, sink)
+}
+
+func (l *QueryLog) Start() error {
+	l.wait.Add(1)
+	go l.writeLoop()
+	return nil
+}
+
+func (l *QueryLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.access.Lock()
+	if l.closed {
+		l.access.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.queue)
+	l.access.Unlock()
+	l.wait.Wait()
+	var errs []error
+	for _, sink := range l.sinks {
+		errs = append(errs, sink.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Log 记录一条查询，按采样率丢弃；写入队列满时丢弃，不阻塞查询
+func (l *QueryLog) Log(entry *Entry) {
+	if l == nil {
+		return
+	}
+	if l.options.SampleRate < 1 && rand.Float64() >= l.options.SampleRate {
+		return
+	}
+	if l.ring != nil {
+		l.ring.Add(entry)
+	}
+	if len(l.sinks) == 0 {
+		return
+	}
+	l.access.RLock()
+	defer l.access.RUnlock()
+	if l.closed {
+		return
+	}
+	select {
+	case l.queue <- entry:
+	default:
+		slog.Debug("query log queue full, drop entry", "qname", entry.Name)
+	}
+}
+
+// Recent 最近的查询，按时间倒序，client/domain 为空时不过滤
+func (l *QueryLog) Recent(client, domain string, limit int) []*Entry {
+	if l == nil || l.ring == nil {
+		return nil
+	}
+	return l.ring.Recent(func(e *Entry) bool {
+		return (client == "" || e.Client == client) && (domain == "" || strings.EqualFold(e.Name, domain))
+	}, limit)
+}
+
+func (l *QueryLog) writeLoop() {
+	defer l.wait.Done()
+	for entry := range l.queue {
+		for _, sink := range l.sinks {
+			if err := sink.Write(entry); err != nil {
+				slog.Error("write query log failed", "err", err)
+			}
+		}
+	}
+}
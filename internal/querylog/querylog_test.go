@@ -0,0 +1,37 @@
+package querylog
+
+import (
+	"io"
+	"sync"
+	"testing"
+)
+
+// 关闭期间仍在处理的查询写入日志时不应 panic
+func TestLogAfterClose(t *testing.T) {
+	l, err := New(&Options{QueueSize: 4, SampleRate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.AddSink(NewWriterSink(io.Discard))
+	if err := l.Start(); err != nil {
+		t.Fatal(err)
+	}
+	var wait sync.WaitGroup
+	for range 8 {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for range 1000 {
+				l.Log(&Entry{Name: "example.com."})
+			}
+		}()
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wait.Wait()
+	l.Log(&Entry{Name: "example.com."})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
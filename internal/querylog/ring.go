@@ -0,0 +1,47 @@
+package querylog
+
+import "sync"
+
+// Ring 固定容量的环形缓冲区，写满后覆盖最旧的条目
+type Ring struct {
+	access  sync.RWMutex
+	entries []*Entry
+	next    int
+	full    bool
+}
+
+func NewRing(size int) *Ring {
+	return &Ring{entries: make([]*Entry, size)}
+}
+
+func (r *Ring) Add(entry *Entry) {
+	r.access.Lock()
+	defer r.access.Unlock()
+	r.entries[r.next] = entry
+	r.next++
+	if r.next == len(r.entries) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// Recent 按时间倒序返回满足条件的条目，limit <= 0 时不限制条数
+func (r *Ring) Recent(match func(*Entry) bool, limit int) (list []*Entry) {
+	r.access.RLock()
+	defer r.access.RUnlock()
+	n := r.next
+	if r.full {
+		n = len(r.entries)
+	}
+	for i := 1; i <= n; i++ {
+		entry := r.entries[(r.next-i+len(r.entries))%len(r.entries)]
+		if !match(entry) {
+			continue
+		}
+		list = append(list, entry)
+		if limit > 0 && len(list) >= limit {
+			break
+		}
+	}
+	return list
+}
@@ -26,7 +26,9 @@ type RuleOptions struct {
 }
 
 type Options struct {
-	MinTTL time.Duration `yaml:"min-ttl" default:"600s"`
+	// 已废弃：上游与缓存的应答不再提高 TTL，最小 TTL 由 cache.min-ttl 在写入缓存时设置
+	MinTTL time.Duration `yaml:"min-ttl"`
+	// 返回给客户端的最大 TTL
 	MaxTTL time.Duration `yaml:"max-ttl" default:"24h"`
 	// 规则
 	Rules []RuleOptions `yaml:"rule"`
@@ -39,6 +41,9 @@ type Rewriter struct {
 
 func NewRewriter(opts Options) (*Rewriter, error) {
 	defaults.Set(&opts)
+	if opts.MinTTL > 0 {
+		slog.Warn("rewrite min-ttl is deprecated and ignored, use cache min-ttl", "min-ttl", opts.MinTTL)
+	}
 	matcher := make([]geodb.Matcher, len(opts.Rules))
 	var err error
 	for i, rule := range opts.Rules {
@@ -64,6 +69,11 @@ func NewRewriter(opts Options) (*Rewriter, error) {
 	}, nil
 }
 
+// Rules 重写规则
+func (r *Rewriter) Rules() []RuleOptions {
+	return r.options.Rules
+}
+
 func (r *Rewriter) Rewrite(domain string, qtype uint16) (*dns.Msg, bool) {
 	query := strings.ToLower(strings.TrimSuffix(domain, "."))
 	for i, rule := range r.options.Rules {
@@ -107,25 +117,18 @@ func (r *Rewriter) Rewrite(domain string, qtype uint16) (*dns.Msg, bool) {
 	return nil, false
 }
 
+// UpdateTTL 按 max-ttl 限制应答记录的 TTL，不提高 TTL，返回的 TTL 随缓存时长递减；
+// OPT 的 TTL 字段为扩展 RCODE 与标志，不调整
 func (r *Rewriter) UpdateTTL(msg *dns.Msg) {
 	max := uint32(r.options.MaxTTL.Seconds())
-	min := uint32(r.options.MinTTL.Seconds())
-	for _, rr := range msg.Answer {
-		if min > 0 && rr.Header().Ttl < min {
-			rr.Header().Ttl = min
-		}
-		if max > 0 && rr.Header().Ttl > max {
-			rr.Header().Ttl = max
-		}
-	}
-	for _, rr := range msg.Ns {
-		if min > 0 && rr.Header().Ttl < min {
-			rr.Header().Ttl = min
-		}
+	if max == 0 {
+		return
 	}
-	for _, rr := range msg.Extra {
-		if min > 0 && rr.Header().Ttl < min {
-			rr.Header().Ttl = min
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if hdr := rr.Header(); hdr.Rrtype != dns.TypeOPT && hdr.Ttl > max {
+				hdr.Ttl = max
+			}
 		}
 	}
 }
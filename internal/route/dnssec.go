@@ -0,0 +1,68 @@
+package route
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/miekg/dns"
+	"github.com/taodev/godns/internal/adapter"
+	"github.com/taodev/godns/internal/dnssec"
+	"github.com/taodev/godns/internal/utils"
+)
+
+// withDO 返回设置了 DO 标志的请求，校验需要签名记录，需要修改时复制 req（req 可能与 in 相同）
+func withDO(req, in *dns.Msg) *dns.Msg {
+	if opt := req.IsEdns0(); opt != nil && opt.Do() {
+		return req
+	}
+	if req == in {
+		req = in.Copy()
+	}
+	if opt := req.IsEdns0(); opt != nil {
+		opt.SetDo()
+	} else {
+		req.SetEdns0(dns.DefaultMsgSize, true)
+	}
+	return req
+}
+
+// validate 校验上游应答，信任链通过 outbound 查询；校验失败时返回带扩展错误的 SERVFAIL。
+// 安全应答设置 AD 标志，客户端未设置 DO 时移除签名记录
+func (r *Router) validate(in, resp *dns.Msg, outbound adapter.Outbound) (*dns.Msg, bool) {
+	q := in.Question[0]
+	secure, err := r.validator.Validate(in, resp, outbound.Tag(), func(req *dns.Msg) (*dns.Msg, error) {
+		resp, _, err := outbound.Exchange(req)
+		return resp, err
+	})
+	if err != nil {
+		slog.Warn("dnssec validation failed", "domain", q.Name, "qtype", dns.TypeToString[q.Qtype], "outbound", outbound.Tag(), "error", err)
+		code := dns.ExtendedErrorCodeDNSBogus
+		if e := (*dnssec.Error)(nil); errors.As(err, &e) {
+			code = e.Code
+		}
+		servfail := utils.NewMsgSERVFAIL(in)
+		utils.SetEDE(servfail, in, code)
+		return servfail, false
+	}
+
+	reqOpt := in.IsEdns0()
+	do := reqOpt != nil && reqOpt.Do()
+	resp.AuthenticatedData = secure && (do || in.AuthenticatedData)
+	if !do {
+		dnssec.Strip(resp, q.Qtype)
+		if opt := resp.IsEdns0(); opt != nil {
+			opt.SetDo(false)
+		}
+	}
+	// 客户端未使用 EDNS 时移除为校验添加的 OPT
+	if reqOpt == nil {
+		extra := resp.Extra[:0]
+		for _, rr := range resp.Extra {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				extra = append(extra, rr)
+			}
+		}
+		resp.Extra = extra
+	}
+	return resp, true
+}
@@ -0,0 +1,228 @@
+package route
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/taodev/godns/internal/adapter"
+	"github.com/taodev/pkg/util"
+)
+
+// ECS 处理方式
+const (
+	// 不处理，透传客户端携带的 ECS
+	ECSModeOff = "off"
+	// 使用客户端携带的 ECS 或客户端 IP 所在子网
+	ECSModeForward = "forward"
+	// 使用固定子网
+	ECSModeCustom = "custom"
+	// 移除 ECS
+	ECSModeStrip = "strip"
+)
+
+// ECS 策略
+type ECSPolicy struct {
+	// 处理方式（off/forward/custom/strip）
+	Mode string `yaml:"mode" default:"off"`
+	// custom 模式使用的子网
+	Subnet string `yaml:"subnet"`
+	// forward 模式 IPv4 子网前缀长度
+	IPv4Prefix int `yaml:"ipv4-prefix" default:"24"`
+	// forward 模式 IPv6 子网前缀长度
+	IPv6Prefix int `yaml:"ipv6-prefix" default:"56"`
+}
+
+// EDNS Client Subnet 配置，按上游覆盖全局策略，未设置的项沿用全局策略
+type ECSOptions struct {
+	ECSPolicy `yaml:",inline"`
+	// 上游标签 -> ECS 策略
+	Outbound map[string]*ECSPolicy `yaml:"outbound"`
+}
+
+type ecsPolicy struct {
+	mode   string
+	subnet netip.Prefix
+	v4, v6 int
+}
+
+type ecs struct {
+	base      ecsPolicy
+	outbounds map[string]ecsPolicy
+
+	// 上游应答出现过的 scope 前缀长度（由长到短），查询缓存时按这些长度匹配
+	access sync.RWMutex
+	scopes [2][]int
+}
+
+func newECS(options *ECSOptions, outbound adapter.OutboundManager) (*ecs, error) {
+	base, err := newECSPolicy(&options.ECSPolicy, ecsPolicy{mode: ECSModeOff, v4: 24, v6: 56})
+	if err != nil {
+		return nil, fmt.Errorf("ecs: %w", err)
+	}
+	e := &ecs{base: base, outbounds: make(map[string]ecsPolicy)}
+	for tag, opt := range options.Outbound {
+		if _, ok := outbound.Get(tag); !ok {
+			return nil, fmt.Errorf("ecs: outbound %s not found", tag)
+		}
+		if e.outbounds[tag], err = newECSPolicy(opt, base); err != nil {
+			return nil, fmt.Errorf("ecs %s: %w", tag, err)
+		}
+	}
+	return e, nil
+}
+
+// newECSPolicy 以 base 为基础创建 ECS 策略
+func newECSPolicy(options *ECSPolicy, base ecsPolicy) (ecsPolicy, error) {
+	p := base
+	if options == nil {
+		return p, nil
+	}
+	if options.Mode != "" {
+		p.mode = options.Mode
+	}
+	if options.IPv4Prefix != 0 {
+		p.v4 = options.IPv4Prefix
+	}
+	if options.IPv6Prefix != 0 {
+		p.v6 = options.IPv6Prefix
+	}
+	if p.v4 < 0 || p.v4 > IPv4BitLen {
+		return p, fmt.Errorf("invalid ipv4-prefix %d", p.v4)
+	}
+	if p.v6 < 0 || p.v6 > IPv6BitLen {
+		return p, fmt.Errorf("invalid ipv6-prefix %d", p.v6)
+	}
+	if options.Subnet != "" {
+		subnet, err := netip.ParsePrefix(options.Subnet)
+		if err != nil {
+			return p, err
+		}
+		p.subnet = netip.PrefixFrom(subnet.Addr().Unmap(), subnet.Bits()).Masked()
+	}
+	switch p.mode {
+	case ECSModeOff, ECSModeForward, ECSModeStrip:
+	case ECSModeCustom:
+		if !p.subnet.IsValid() {
+			return p, fmt.Errorf("custom mode requires subnet")
+		}
+	default:
+		return p, fmt.Errorf("unknown mode %s", p.mode)
+	}
+	return p, nil
+}
+
+func (e *ecs) policy(tag string) ecsPolicy {
+	if p, ok := e.outbounds[tag]; ok {
+		return p
+	}
+	return e.base
+}
+
+// subnet 发往上游 tag 的请求携带的 ECS 子网，无效表示不携带
+func (e *ecs) subnet(tag string, in *dns.Msg, client netip.Addr) netip.Prefix {
+	p := e.policy(tag)
+	switch p.mode {
+	case ECSModeOff:
+		subnet, _ := ecsFromMsg(in)
+		return subnet
+	case ECSModeCustom:
+		return p.subnet
+	case ECSModeForward:
+		// 客户端已携带 ECS 时沿用，但不超过配置的前缀长度
+		subnet, _ := ecsFromMsg(in)
+		if !subnet.IsValid() {
+			if !client.IsValid() || util.IsSpecialPurpose(client) {
+				return netip.Prefix{}
+			}
+			subnet = netip.PrefixFrom(client.Unmap(), client.Unmap().BitLen())
+		}
+		bits := p.v6
+		if subnet.Addr().Is4() {
+			bits = p.v4
+		}
+		if subnet.Bits() > bits {
+			subnet, _ = subnet.Addr().Prefix(bits)
+		}
+		return subnet
+	}
+	return netip.Prefix{}
+}
+
+// apply 返回按上游 tag 的 ECS 策略处理后的请求，需要修改时复制 in
+func (e *ecs) apply(in *dns.Msg, tag string, client netip.Addr) *dns.Msg {
+	if e.policy(tag).mode == ECSModeOff {
+		return in
+	}
+	req := in.Copy()
+	if subnet := e.subnet(tag, in, client); subnet.IsValid() {
+		setECS(req, subnet)
+	} else {
+		removeECS(req)
+	}
+	return req
+}
+
+// scoped 应答适用的子网：请求子网按应答的 scope 截断，scope 大于请求前缀长度时按请求前缀长度（RFC 7871 7.3.1）
+func (e *ecs) scoped(source netip.Prefix, scope int) netip.Prefix {
+	if !source.IsValid() || scope <= 0 {
+		return netip.Prefix{}
+	}
+	subnet := netip.PrefixFrom(source.Addr(), min(scope, source.Bits())).Masked()
+	family := familyIndex(subnet)
+	e.access.RLock()
+	seen := slices.Contains(e.scopes[family], subnet.Bits())
+	e.access.RUnlock()
+	if !seen {
+		e.access.Lock()
+		if !slices.Contains(e.scopes[family], subnet.Bits()) {
+			scopes := append(slices.Clone(e.scopes[family]), subnet.Bits())
+			slices.SortFunc(scopes, func(a, b int) int { return b - a })
+			e.scopes[family] = scopes
+		}
+		e.access.Unlock()
+	}
+	return subnet
+}
+
+// candidates 可能缓存了 subnet 内应答的子网，前缀由长到短
+func (e *ecs) candidates(subnet netip.Prefix) []netip.Prefix {
+	e.access.RLock()
+	scopes := e.scopes[familyIndex(subnet)]
+	e.access.RUnlock()
+	var out []netip.Prefix
+	for _, bits := range scopes {
+		if bits <= subnet.Bits() {
+			out = append(out, netip.PrefixFrom(subnet.Addr(), bits).Masked())
+		}
+	}
+	return out
+}
+
+func familyIndex(subnet netip.Prefix) int {
+	if subnet.Addr().Is4() {
+		return 0
+	}
+	return 1
+}
+
+// reply 客户端携带 ECS 时在应答中返回其子网，scope 为应答适用的子网前缀长度（RFC 7871 7.2.2），
+// subnet 无效表示应答与子网无关
+func (e *ecs) reply(resp, request *dns.Msg, subnet netip.Prefix) {
+	source, _ := ecsFromMsg(request)
+	if resp == nil || !source.IsValid() {
+		return
+	}
+	var scope int
+	if subnet.IsValid() {
+		scope = min(subnet.Bits(), source.Bits())
+	}
+	setECS(resp, source)
+	for _, o := range resp.IsEdns0().Option {
+		if sn, ok := o.(*dns.EDNS0_SUBNET); ok {
+			sn.SourceScope = uint8(scope)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package route
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+
+	"github.com/miekg/dns"
+	"github.com/taodev/godns/internal/adapter"
+)
+
+// 应答校验配置：首选上游的 A/AAAA 应答中有不可信 IP 时丢弃，改用备用上游查询
+type FallbackOptions struct {
+	// 需要校验应答的上游，为空时校验默认上游
+	Primary []string `yaml:"primary"`
+	// 备用上游，为空时不启用
+	Outbound string `yaml:"outbound"`
+	// 可信 IP 的 GeoIP 国家代码（如 cn、private）
+	GeoIP []string `yaml:"geoip"`
+	// 可信 IP 网段
+	CIDR []string `yaml:"cidr"`
+}
+
+type fallback struct {
+	primary  []string
+	outbound adapter.Outbound
+	trusted  ipSet
+}
+
+func newFallback(options *FallbackOptions, endpoint adapter.Outbound, outbound adapter.OutboundManager) (*fallback, error) {
+	f := &fallback{primary: options.Primary}
+	var ok bool
+	if f.outbound, ok = outbound.Get(options.Outbound); !ok {
+		return nil, fmt.Errorf("fallback outbound %s not found", options.Outbound)
+	}
+	if len(f.primary) == 0 {
+		f.primary = []string{endpoint.Tag()}
+	}
+	for _, tag := range f.primary {
+		if _, ok := outbound.Get(tag); !ok {
+			return nil, fmt.Errorf("fallback primary outbound %s not found", tag)
+		}
+	}
+	for _, code := range options.GeoIP {
+		if err := f.trusted.addGeoIP(code); err != nil {
+			return nil, err
+		}
+	}
+	for _, cidr := range options.CIDR {
+		if err := f.trusted.addCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("fallback: %w", err)
+		}
+	}
+	if len(f.trusted.ranges) == 0 {
+		return nil, fmt.Errorf("fallback requires geoip or cidr")
+	}
+	f.trusted.build()
+	return f, nil
+}
+
+// untrusted 应答来自需要校验的上游且含有不可信 IP 时返回该 IP
+func (f *fallback) untrusted(tag string, resp *dns.Msg) (netip.Addr, bool) {
+	if !slices.Contains(f.primary, tag) {
+		return netip.Addr{}, false
+	}
+	for _, rr := range resp.Answer {
+		var addr netip.Addr
+		switch rr := rr.(type) {
+		case *dns.A:
+			addr, _ = netip.AddrFromSlice(rr.A)
+		case *dns.AAAA:
+			addr, _ = netip.AddrFromSlice(rr.AAAA)
+		default:
+			continue
+		}
+		if addr = addr.Unmap(); !f.trusted.contains(addr) {
+			return addr, true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// exchange 通过 exchange 查询 outbound，应答不可信时改用备用上游
+func (f *fallback) exchange(in *dns.Msg, outbound adapter.Outbound, exchange func(adapter.Outbound) (*dns.Msg, error)) (*dns.Msg, adapter.Outbound, error) {
+	resp, err := exchange(outbound)
+	if err != nil || f == nil {
+		return resp, outbound, err
+	}
+	if addr, ok := f.untrusted(outbound.Tag(), resp); ok {
+		slog.Debug("untrusted answer, fallback", "domain", in.Question[0].Name, "outbound", outbound.Tag(), "ip", addr, "fallback", f.outbound.Tag())
+		resp, err = exchange(f.outbound)
+		return resp, f.outbound, err
+	}
+	return resp, outbound, nil
+}
@@ -0,0 +1,122 @@
+package route
+
+import (
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/taodev/pkg/geodb"
+)
+
+// GeoIP 数据文件路径
+var GeoIPPath = "geoip.dat"
+
+type ipRange struct {
+	from, to netip.Addr
+}
+
+// ipSet 合并后的有序地址区间，二分查找
+type ipSet struct {
+	ranges []ipRange
+}
+
+func (s *ipSet) addPrefix(prefix netip.Prefix) {
+	prefix = prefix.Masked()
+	s.ranges = append(s.ranges, ipRange{from: prefix.Addr(), to: lastAddr(prefix)})
+}
+
+// addCIDR 添加网段，支持单个 IP
+func (s *ipSet) addCIDR(cidr string) error {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		addr, addrErr := netip.ParseAddr(cidr)
+		if addrErr != nil {
+			return err
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	s.addPrefix(prefix)
+	return nil
+}
+
+// addGeoIP 添加 GeoIP 国家代码（如 cn、private）对应的网段
+func (s *ipSet) addGeoIP(code string) error {
+	path := GeoIPPath
+	if !strings.HasSuffix(path, ".dat") {
+		path += ".dat"
+	}
+	path, err := filepath.Abs(filepath.Join(geodb.DataDir, path))
+	if err != nil {
+		return err
+	}
+	geoip, err := geodb.UnmarshalGeoIp(path, code)
+	if err != nil {
+		return err
+	}
+	if geoip.InverseMatch {
+		return fmt.Errorf("geoip:%s - inverse match is not supported", code)
+	}
+	for _, cidr := range geoip.Cidr {
+		addr, ok := netip.AddrFromSlice(cidr.Ip)
+		if !ok {
+			return fmt.Errorf("geoip:%s - bad ip %v", code, cidr.Ip)
+		}
+		s.addPrefix(netip.PrefixFrom(addr.Unmap(), int(cidr.Prefix)))
+	}
+	return nil
+}
+
+// build 排序并合并重叠区间，添加完成后调用
+func (s *ipSet) build() {
+	slices.SortFunc(s.ranges, func(a, b ipRange) int {
+		return a.from.Compare(b.from)
+	})
+	merged := s.ranges[:0]
+	for _, r := range s.ranges {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			// 重叠或相邻，last.to 为地址族最大地址时 next 无效
+			next := last.to.Next()
+			if last.from.Is4() == r.from.Is4() && (!next.IsValid() || r.from.Compare(next) <= 0) {
+				if r.to.Compare(last.to) > 0 {
+					last.to = r.to
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	s.ranges = merged
+}
+
+func (s *ipSet) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	i, found := slices.BinarySearchFunc(s.ranges, addr, func(r ipRange, addr netip.Addr) int {
+		return r.from.Compare(addr)
+	})
+	if found {
+		return true
+	}
+	return i > 0 && s.ranges[i-1].to.Compare(addr) >= 0 && s.ranges[i-1].from.Is4() == addr.Is4()
+}
+
+// lastAddr 网段的最后一个地址
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	bits := prefix.Bits()
+	for i := range b {
+		switch {
+		case bits >= 8:
+			bits -= 8
+		case bits > 0:
+			b[i] |= 0xff >> bits
+			bits = 0
+		default:
+			b[i] = 0xff
+		}
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
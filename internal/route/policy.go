@@ -0,0 +1,105 @@
+package route
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/taodev/godns/internal/adapter"
+	"github.com/taodev/godns/internal/rewrite"
+)
+
+// 客户端策略配置，未设置的项沿用全局配置
+type PolicyOptions struct {
+	// 策略名
+	Name string `yaml:"name"`
+	// 客户端网段，支持单个 IP
+	CIDR []string `yaml:"cidr"`
+	// 入站类型，为空时不限制
+	Inbound []string `yaml:"inbound"`
+	// 默认上游
+	Default string `yaml:"default"`
+	// 是否启用拦截
+	Filter *bool `yaml:"filter"`
+	// 是否阻止 AAAA 查询（IPv6）
+	BlockAAAA *bool `yaml:"block-aaaa"`
+	// 重写规则，设置后替代全局重写规则
+	Rewrite *rewrite.Options `yaml:"rewrite"`
+}
+
+// policy 生效的客户端策略
+type policy struct {
+	name     string
+	prefixes []netip.Prefix
+	inbounds []string
+	endpoint adapter.Outbound
+	rewriter *rewrite.Rewriter
+	filter   bool
+	// 阻止 AAAA
+	blockAAAA bool
+	// 缓存隔离，默认上游或 AAAA 过滤不同时不与其他策略共享缓存
+	cacheScope string
+}
+
+// newPolicy 以全局策略 base 为基础创建客户端策略
+func newPolicy(options *PolicyOptions, base *policy, outbound adapter.OutboundManager) (*policy, error) {
+	if options.Name == "" {
+		return nil, fmt.Errorf("policy name is required")
+	}
+	if strings.Contains(options.Name, scopeSep) {
+		return nil, fmt.Errorf("policy name %s must not contain %q", options.Name, scopeSep)
+	}
+	p := *base
+	p.name = options.Name
+	p.inbounds = options.Inbound
+	for _, cidr := range options.CIDR {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(cidr)
+			if addrErr != nil {
+				return nil, fmt.Errorf("policy %s: %w", options.Name, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		p.prefixes = append(p.prefixes, prefix.Masked())
+	}
+	if options.Default != "" {
+		var ok bool
+		if p.endpoint, ok = outbound.Get(options.Default); !ok {
+			return nil, fmt.Errorf("policy %s: outbound %s not found", options.Name, options.Default)
+		}
+		p.cacheScope = options.Name
+	}
+	if options.Filter != nil {
+		p.filter = *options.Filter
+	}
+	if options.BlockAAAA != nil && *options.BlockAAAA != base.blockAAAA {
+		p.blockAAAA = *options.BlockAAAA
+		p.cacheScope = options.Name
+	}
+	if options.Rewrite != nil {
+		rewriter, err := rewrite.NewRewriter(*options.Rewrite)
+		if err != nil {
+			return nil, fmt.Errorf("policy %s: %w", options.Name, err)
+		}
+		p.rewriter = rewriter
+	}
+	return &p, nil
+}
+
+// match 客户端 IP 与入站是否命中策略，未配置网段时匹配所有客户端
+func (p *policy) match(inbound string, addr netip.Addr) bool {
+	if len(p.inbounds) > 0 && !slices.Contains(p.inbounds, inbound) {
+		return false
+	}
+	if len(p.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
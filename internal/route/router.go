@@ -6,14 +6,20 @@ import (
 	"net"
 	"net/netip"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/taodev/godns/internal/adapter"
 	"github.com/taodev/godns/internal/cache"
+	"github.com/taodev/godns/internal/dnssec"
+	"github.com/taodev/godns/internal/filter"
+	"github.com/taodev/godns/internal/metrics"
+	"github.com/taodev/godns/internal/querylog"
 	"github.com/taodev/godns/internal/rewrite"
 	"github.com/taodev/godns/internal/utils"
-	"github.com/taodev/pkg/geodb"
-	"github.com/taodev/pkg/util"
+	"github.com/taodev/godns/internal/zone"
+	"golang.org/x/sync/singleflight"
 )
 
 type Options struct {
@@ -23,29 +29,57 @@ type Options struct {
 	Rules []string `yaml:"rules"`
 	// 默认上游
 	Default string `yaml:"default"`
+	// 客户端策略，按顺序匹配，先命中者生效
+	Policies []*PolicyOptions `yaml:"policies"`
+	// 应答校验与备用上游
+	Fallback FallbackOptions `yaml:"fallback"`
+	// EDNS Client Subnet
+	ECS ECSOptions `yaml:"ecs"`
+	// DNSSEC 校验
+	DNSSEC dnssec.Options `yaml:"dnssec"`
 }
 
+// 缓存隔离域中策略名与上游标签的分隔符
+const scopeSep = "@"
+
 type Router struct {
 	options  *Options
-	rules    []*geodb.Rule
+	rules    []*Rule
 	outbound adapter.OutboundManager
 	endpoint adapter.Outbound
 	rewriter *rewrite.Rewriter
+	filter   *filter.Filter
+	zones    *zone.Zones
 	cache    *cache.Cache
+	querylog *querylog.QueryLog
+	fallback *fallback
+	ecs      *ecs
+	// DNSSEC 校验，未启用时为空
+	validator *dnssec.Validator
+	// 合并相同的并发上游查询
+	flight singleflight.Group
+	// 上游刷新失败的缓存键，failure recheck timer 到期前直接返回过期应答（RFC 8767）
+	staleFailed sync.Map
+	// 全局策略与客户端策略
+	policy       *policy
+	policies     []*policy
+	policyByName map[string]*policy
 }
 
-func New(options *Options, outbound adapter.OutboundManager, rewriter *rewrite.Rewriter, cache *cache.Cache) (*Router, error) {
+func New(options *Options, outbound adapter.OutboundManager, rewriter *rewrite.Rewriter, filter *filter.Filter, zones *zone.Zones, cache *cache.Cache, querylog *querylog.QueryLog) (*Router, error) {
 	router := &Router{
 		options:  options,
-		rules:    make([]*geodb.Rule, 0),
+		rules:    make([]*Rule, 0),
 		outbound: outbound,
 		rewriter: rewriter,
+		filter:   filter,
+		zones:    zones,
 		cache:    cache,
+		querylog: querylog,
 	}
-	cache.SetQuery(router)
 	router.endpoint, _ = outbound.Get(options.Default)
 	for _, opt := range options.Rules {
-		matcher, err := geodb.LoadRule(opt)
+		matcher, err := ParseRule(opt)
 		if err != nil {
 			return nil, err
 		}
@@ -61,85 +95,429 @@ func New(options *Options, outbound adapter.OutboundManager, rewriter *rewrite.R
 	if router.endpoint == nil {
 		return nil, fmt.Errorf("default outbound %s not found", router.options.Default)
 	}
+
+	var err error
+	if options.Fallback.Outbound != "" {
+		if router.fallback, err = newFallback(&options.Fallback, router.endpoint, outbound); err != nil {
+			return nil, err
+		}
+	}
+	if router.ecs, err = newECS(&options.ECS, outbound); err != nil {
+		return nil, err
+	}
+	if options.DNSSEC.Enable {
+		if router.validator, err = dnssec.New(&options.DNSSEC); err != nil {
+			return nil, err
+		}
+	}
+
+	router.policy = &policy{
+		endpoint:  router.endpoint,
+		rewriter:  rewriter,
+		filter:    true,
+		blockAAAA: options.BlockAAAA,
+	}
+	router.policyByName = make(map[string]*policy)
+	for _, opt := range options.Policies {
+		p, err := newPolicy(opt, router.policy, outbound)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := router.policyByName[p.name]; ok {
+			return nil, fmt.Errorf("duplicate policy %s", p.name)
+		}
+		router.policies = append(router.policies, p)
+		router.policyByName[p.name] = p
+	}
 	return router, nil
 }
 
+// matchPolicy 客户端命中的策略，未命中时使用全局策略
+func (r *Router) matchPolicy(inbound string, addr netip.Addr) *policy {
+	for _, p := range r.policies {
+		if p.match(inbound, addr) {
+			return p
+		}
+	}
+	return r.policy
+}
+
+// Rules 路由规则
+func (r *Router) Rules() []string {
+	return r.options.Rules
+}
+
+// Filter 拦截规则
+func (r *Router) Filter() *filter.Filter {
+	return r.filter
+}
+
+// Zones 本地权威区域
+func (r *Router) Zones() *zone.Zones {
+	return r.zones
+}
+
+// Default 默认上游标签
+func (r *Router) Default() string {
+	return r.endpoint.Tag()
+}
+
 func (r *Router) Exchange(request *dns.Msg, inbound string, ip string) (resp *dns.Msg, err error) {
-	if resp := r.validateRequest(request); resp != nil {
+	var (
+		source      = querylog.SourceUpstream
+		outboundTag string
+		start       = time.Now()
+	)
+	addr, _ := netip.ParseAddr(ip)
+	addr = addr.Unmap()
+	p := r.matchPolicy(inbound, addr)
+	if len(request.Question) > 0 {
+		defer func() {
+			q := request.Question[0]
+			metrics.ObserveQuery(inbound, q.Qtype, resp, outboundTag)
+			r.logQuery(q, inbound, ip, p.name, source, outboundTag, resp, err, time.Since(start))
+		}()
+	}
+	if resp := r.validateRequest(request, p); resp != nil {
+		source, outboundTag = querylog.SourceReject, "reject"
 		return resp, nil
 	}
+	resp, source, outboundTag, err = r.answer(request, p, inbound, addr, ip, 0)
+	return resp, err
+}
+
+// answer 按重写、本地区域、拦截、上游的顺序应答，depth 为本地区域应答中区域外目标的解析深度
+func (r *Router) answer(request *dns.Msg, p *policy, inbound string, addr netip.Addr, ip string, depth int) (resp *dns.Msg, source, outboundTag string, err error) {
 	q := request.Question[0]
 	// 检查是否需要重写
-	if rewrite := r.rewrite(request); rewrite != nil {
-		slog.Info("request", "upstream", "rewrite", "domain", q.Name, "qtype", dns.TypeToString[q.Qtype], "inbound", inbound, "client", ip)
-		return rewrite, nil
+	if rewrite := r.rewrite(request, p); rewrite != nil {
+		source, outboundTag = querylog.SourceRewrite, "rewrite"
+		metrics.ObserveRewrite()
+		slog.Debug("route", "qtype", dns.TypeToString[q.Qtype], "domain", q.Name, "inbound", inbound, "outbound", outboundTag, "ip", ip)
+		return rewrite, source, outboundTag, nil
 	}
 
-	// // 查询缓存
-	cv, ok := r.cache.GetAndUpdate(q.Name, q.Qtype, ip)
-	if ok {
-		resp = cv.M.Copy()
+	// 本地权威区域
+	if local, ok := r.zones.Lookup(request); ok {
+		slog.Debug("route", "qtype", dns.TypeToString[q.Qtype], "domain", q.Name, "inbound", inbound, "outbound", "zone", "ip", ip)
+		return r.resolveZone(request, local, p, inbound, addr, ip, depth)
+	}
+
+	// 拦截
+	if block, ok := r.block(request, p); ok {
+		source, outboundTag = querylog.SourceBlock, "block"
+		slog.Debug("route", "qtype", dns.TypeToString[q.Qtype], "domain", q.Name, "inbound", inbound, "outbound", outboundTag, "ip", ip)
+		return block, source, outboundTag, nil
+	}
+	return r.upstream(request, p, inbound, addr, ip)
+}
+
+// upstream 按路由规则选择上游，优先使用缓存
+func (r *Router) upstream(request *dns.Msg, p *policy, inbound string, addr netip.Addr, ip string) (resp *dns.Msg, source, outboundTag string, err error) {
+	q := request.Question[0]
+	source = querylog.SourceUpstream
+	// 路由，规则依赖客户端、入站或时间时按上游隔离缓存
+	outbound, dynamic := r.route(NewContext(q.Name, q.Qtype, addr, inbound), p.endpoint)
+	scope := p.cacheScope
+	if dynamic {
+		scope += scopeSep + outbound.Tag()
+	}
+
+	// 查询缓存，上游应答与 ECS 子网相关时按子网隔离
+	key := cache.NewKey(request, scope)
+	if subnet := r.ecs.subnet(outbound.Tag(), request, addr); subnet.IsValid() {
+		// 缓存键为应答 scope 截断后的子网，从长到短匹配
+		for _, subnet := range r.ecs.candidates(subnet) {
+			probe := key
+			probe.Subnet = subnet
+			if _, ok := r.cache.Get(probe); ok {
+				key = probe
+				break
+			}
+		}
+	}
+	cv, ok := r.cache.GetAndUpdate(key)
+	if ok && !cv.IsExpired() {
+		source, outboundTag = querylog.SourceCache, "cache"
+		resp = cv.Msg()
+		rcode := resp.Rcode
 		resp.SetReply(request)
-		slog.Info("route", "qtype", dns.TypeToString[q.Qtype], "domain", q.Name, "inbound", inbound, "outbound", "cache", "ip", ip)
-		return resp, nil
+		resp.Rcode = rcode
+		r.rewriter.UpdateTTL(resp)
+		r.ecs.reply(resp, request, key.Subnet)
+		slog.Debug("route", "qtype", dns.TypeToString[q.Qtype], "domain", q.Name, "inbound", inbound, "outbound", outboundTag, "ip", ip)
+		return resp, source, outboundTag, nil
+	}
+	if ok {
+		var (
+			stale  bool
+			subnet netip.Prefix
+		)
+		if resp, outboundTag, subnet, stale = r.serveStale(request, cv, outbound, p, addr, key, ip); stale {
+			source, outboundTag = querylog.SourceStale, "cache"
+		}
+		r.rewriter.UpdateTTL(resp)
+		r.ecs.reply(resp, request, subnet)
+		slog.Debug("route", "qtype", dns.TypeToString[q.Qtype], "domain", q.Name, "inbound", inbound, "outbound", outboundTag, "ip", ip, "stale", stale)
+		return resp, source, outboundTag, nil
 	}
 
-	resp, outboundTag, err := r.Resolve(request, net.ParseIP(ip))
+	var subnet netip.Prefix
+	resp, outboundTag, subnet, err = r.exchange(request, outbound, p, addr, key, ip)
 	if err != nil {
 		slog.Debug("route", "qtype", dns.TypeToString[q.Qtype], "domain", q.Name, "outbound", outboundTag, "error", err)
-		return nil, err
+		return nil, source, outboundTag, err
 	}
+	// 缓存写入时已按缓存配置调整 TTL，返回给客户端时只按重写配置的 max-ttl 限制
+	r.rewriter.UpdateTTL(resp)
+	r.ecs.reply(resp, request, subnet)
+	slog.Debug("route", "qtype", dns.TypeToString[q.Qtype], "domain", q.Name, "inbound", inbound, "outbound", outboundTag, "ip", ip, "response", resp.Answer)
+	return resp, source, outboundTag, nil
+}
 
-	if resp.Rcode != dns.RcodeSuccess {
-		return resp, nil
+// exchange 查询上游并写入缓存，是否缓存及缓存时长由应答决定；
+// 缓存键与发往上游的 ECS 子网相同的并发查询合并为一次，subnet 为应答适用的 ECS 子网
+func (r *Router) exchange(request *dns.Msg, outbound adapter.Outbound, p *policy, addr netip.Addr, key cache.Key, ip string) (resp *dns.Msg, outboundTag string, subnet netip.Prefix, err error) {
+	type result struct {
+		resp        *dns.Msg
+		outboundTag string
+		subnet      netip.Prefix
+	}
+	key.Subnet = r.ecs.subnet(outbound.Tag(), request, addr)
+	v, err, shared := r.flight.Do(key.String(), func() (any, error) {
+		resp, outboundTag, subnet, err := r.resolve(request, outbound, p, addr)
+		if err == nil {
+			key.Subnet = subnet
+			r.cache.Set(key, resp, ip)
+		}
+		return result{resp, outboundTag, subnet}, err
+	})
+	res := v.(result)
+	resp = res.resp
+	if shared && resp != nil {
+		// 应答被多个请求共享，各自复制，问题区（大小写）与 RD、CD 标志沿用各自的请求
+		resp = resp.Copy()
+		rcode := resp.Rcode
+		resp.SetReply(request)
+		resp.Rcode = rcode
+	}
+	return resp, res.outboundTag, res.subnet, err
+}
+
+// serveStale 缓存条目已过期时查询上游，上游失败或超时时返回过期应答（RFC 8767），
+// 上游刷新失败后 stale-recheck 时长内直接返回过期应答，不再查询上游；
+// stale 为 false 时 resp 为上游的新应答
+func (r *Router) serveStale(request *dns.Msg, cv cache.CacheValue, outbound adapter.Outbound, p *policy, addr netip.Addr, key cache.Key, ip string) (resp *dns.Msg, outboundTag string, subnet netip.Prefix, stale bool) {
+	if _, failed := r.staleFailed.Load(key); failed {
+		slog.Debug("upstream recently failed, serve stale", "domain", request.Question[0].Name, "outbound", outbound.Tag())
+	} else if resp, outboundTag, subnet, ok := r.refreshStale(request, outbound, p, addr, key, ip); ok {
+		return resp, outboundTag, subnet, false
+	}
+	resp = cv.Stale(r.cache.StaleTTL())
+	rcode := resp.Rcode
+	resp.SetReply(request)
+	resp.Rcode = rcode
+	utils.SetEDE(resp, request, dns.ExtendedErrorCodeStaleAnswer)
+	return resp, outbound.Tag(), key.Subnet, true
+}
+
+// refreshStale 查询上游刷新过期条目，stale-timeout 内成功应答时 ok 为 true；
+// 上游失败时启动该缓存键的 failure recheck timer
+func (r *Router) refreshStale(request *dns.Msg, outbound adapter.Outbound, p *policy, addr netip.Addr, key cache.Key, ip string) (resp *dns.Msg, outboundTag string, subnet netip.Prefix, ok bool) {
+	type result struct {
+		resp        *dns.Msg
+		outboundTag string
+		subnet      netip.Prefix
+		err         error
 	}
+	// 超时后请求仍在使用，使用副本
+	req := request.Copy()
+	ch := make(chan result, 1)
+	go func() {
+		resp, outboundTag, subnet, err := r.exchange(req, outbound, p, addr, key, ip)
+		if err != nil || (resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError) {
+			r.recheckStale(key)
+		}
+		ch <- result{resp, outboundTag, subnet, err}
+	}()
+	timer := time.NewTimer(r.cache.StaleTimeout())
+	defer timer.Stop()
+	select {
+	case res := <-ch:
+		if res.err == nil && (res.resp.Rcode == dns.RcodeSuccess || res.resp.Rcode == dns.RcodeNameError) {
+			res.resp.Id = request.Id
+			return res.resp, res.outboundTag, res.subnet, true
+		}
+		slog.Debug("upstream failed, serve stale", "domain", request.Question[0].Name, "outbound", res.outboundTag, "error", res.err)
+	case <-timer.C:
+		slog.Debug("upstream timeout, serve stale", "domain", request.Question[0].Name, "outbound", outbound.Tag())
+	}
+	return nil, "", netip.Prefix{}, false
+}
 
-	// 缓存
-	r.cache.Set(q.Name, q.Qtype, resp, ip)
-	slog.Info("route", "qtype", dns.TypeToString[q.Qtype], "domain", q.Name, "inbound", inbound, "outbound", outboundTag, "ip", ip, "response", resp.Answer)
-	return resp, nil
+// recheckStale 启动缓存键的 failure recheck timer，到期后再次查询上游
+func (r *Router) recheckStale(key cache.Key) {
+	recheck := r.cache.StaleRecheck()
+	if recheck <= 0 {
+		return
+	}
+	if _, loaded := r.staleFailed.LoadOrStore(key, struct{}{}); !loaded {
+		time.AfterFunc(recheck, func() { r.staleFailed.Delete(key) })
+	}
 }
 
-func (r *Router) Resolve(in *dns.Msg, ip net.IP) (resp *dns.Msg, outboundTag string, err error) {
-	q := in.Question[0]
-	outbound := r.Route(q.Name)
+// logQuery 写入查询日志，resp 为空时视为 SERVFAIL
+func (r *Router) logQuery(q dns.Question, inbound, ip, policy, source, outboundTag string, resp *dns.Msg, err error, elapsed time.Duration) {
+	if r.querylog == nil {
+		return
+	}
+	entry := &querylog.Entry{
+		Time:    time.Now(),
+		Client:  ip,
+		Inbound: inbound,
+		Policy:  policy,
+		Name:    q.Name,
+		Type:    dns.TypeToString[q.Qtype],
+		Source:  source,
+		Rcode:   dns.RcodeToString[dns.RcodeServerFailure],
+		Elapsed: float64(elapsed.Microseconds()) / 1000,
+	}
+	if source == querylog.SourceUpstream {
+		entry.Outbound = outboundTag
+	}
+	if resp != nil {
+		entry.Rcode = dns.RcodeToString[resp.Rcode]
+		for _, rr := range resp.Answer {
+			entry.Answers = append(entry.Answers, rr.String())
+		}
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	r.querylog.Log(entry)
+}
+
+// Resolve 按缓存隔离域查询上游并写入缓存，供缓存刷新使用，与缓存键相同的前台查询合并。
+// scope 为策略名，规则依赖客户端、入站或时间时附带上游标签（policy@outbound）
+func (r *Router) Resolve(in *dns.Msg, scope string, ip net.IP) (resp *dns.Msg, outboundTag string, err error) {
+	name, tag, pinned := strings.Cut(scope, scopeSep)
+	p, ok := r.policyByName[name]
+	if !ok {
+		p = r.policy
+	}
+	addr, _ := netip.AddrFromSlice(ip)
+	addr = addr.Unmap()
+	var outbound adapter.Outbound
+	if pinned {
+		outbound, _ = r.outbound.Get(tag)
+	}
 	if outbound == nil {
-		return utils.NewMsgSERVFAIL(in), "", nil
+		q := in.Question[0]
+		outbound, _ = r.route(NewContext(q.Name, q.Qtype, addr, ""), p.endpoint)
+	}
+	var client string
+	if addr.IsValid() {
+		client = addr.String()
 	}
+	resp, outboundTag, _, err = r.exchange(in, outbound, p, addr, cache.NewKey(in, scope), client)
+	return resp, outboundTag, err
+}
 
+// resolve 查询上游，subnet 为应答适用的 ECS 子网，应答与子网无关（scope 为 0）时无效
+func (r *Router) resolve(in *dns.Msg, outbound adapter.Outbound, p *policy, client netip.Addr) (resp *dns.Msg, outboundTag string, subnet netip.Prefix, err error) {
+	if outbound == nil {
+		return utils.NewMsgSERVFAIL(in), "", subnet, nil
+	}
+
+	// 客户端设置 CD 时不校验（RFC 4035 3.2.2）
+	validate := r.validator != nil && !in.CheckingDisabled
 	in.RecursionDesired = true
-	// r.processECS(in, ip)
-	if resp, _, err = outbound.Exchange(in); err != nil {
-		return utils.NewMsgSERVFAIL(in), "", err
+	resp, outbound, err = r.fallback.exchange(in, outbound, func(outbound adapter.Outbound) (*dns.Msg, error) {
+		req := r.ecs.apply(in, outbound.Tag(), client)
+		if validate {
+			req = withDO(req, in)
+		}
+		resp, _, err := outbound.Exchange(req)
+		if err == nil {
+			// 应答的 scope 非 0 时按 scope 截断后的请求子网缓存，ECS 由 Exchange 按各自的请求返回
+			_, scope := ecsFromMsg(resp)
+			source, _ := ecsFromMsg(req)
+			subnet = r.ecs.scoped(source, scope)
+			removeECS(resp)
+		}
+		return resp, err
+	})
+	if err != nil {
+		return utils.NewMsgSERVFAIL(in), outbound.Tag(), subnet, err
+	}
+	if validate {
+		var ok bool
+		if resp, ok = r.validate(in, resp, outbound); !ok {
+			return resp, outbound.Tag(), netip.Prefix{}, nil
+		}
 	}
 	var answer []dns.RR
 	for _, rr := range resp.Answer {
 		// 判断是否禁止 AAAA
-		if !r.options.BlockAAAA || rr.Header().Rrtype != dns.TypeAAAA {
+		if !p.blockAAAA || rr.Header().Rrtype != dns.TypeAAAA {
 			answer = append(answer, rr)
 		}
 		resp.Answer = answer
 	}
+	rcode := resp.Rcode
 	resp.SetReply(in)
+	resp.Rcode = rcode
 	resp.Authoritative = true
 	resp.RecursionAvailable = true
 	resp.Id = in.Id
-	r.rewriter.UpdateTTL(resp)
-	return resp, outbound.Tag(), nil
+	return resp, outbound.Tag(), subnet, nil
+}
+
+// Explain 按查询流程（客户端策略的重写、本地区域、拦截、路由）返回域名的处理方式，供管理接口使用；
+// outbound 为上游标签或 rewrite、zone、block，policy 为命中的客户端策略名，全局策略时为空
+func (r *Router) Explain(name string, qtype uint16, client netip.Addr, inbound string) (policy, outbound string) {
+	client = client.Unmap()
+	p := r.matchPolicy(inbound, client)
+	if _, ok := p.rewriter.Rewrite(name, qtype); ok {
+		return p.name, "rewrite"
+	}
+	switch {
+	case r.zones.Contains(name):
+		return p.name, "zone"
+	case p.filter && r.filter.Match(name):
+		return p.name, "block"
+	}
+	if outbound, _ := r.route(NewContext(name, qtype, client, inbound), p.endpoint); outbound != nil {
+		return p.name, outbound.Tag()
+	}
+	return p.name, ""
+}
+
+// Route 按路由规则与客户端策略选择上游
+func (r *Router) Route(ctx *Context) (outbound adapter.Outbound) {
+	outbound, _ = r.route(ctx, r.matchPolicy(ctx.Inbound, ctx.Client).endpoint)
+	return outbound
 }
 
-func (r *Router) Route(domain string) (outbound adapter.Outbound) {
-	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+// route 按路由规则选择上游，未命中或上游熔断时使用 endpoint；
+// dynamic 表示命中的规则依赖客户端、入站或时间
+func (r *Router) route(ctx *Context, endpoint adapter.Outbound) (outbound adapter.Outbound, dynamic bool) {
 	for _, rule := range r.rules {
-		if action, ok := rule.Match(&geodb.Context{Domain: domain}); ok {
-			outbound, _ = r.outbound.Get(action)
-			return outbound
+		if !rule.Match(ctx) {
+			continue
 		}
+		// 上游已熔断时使用默认上游
+		if !r.outbound.Healthy(rule.Action) {
+			slog.Debug("route outbound unhealthy, fallback to default", "outbound", rule.Action, "domain", ctx.Domain)
+			return endpoint, rule.dynamic
+		}
+		outbound, _ = r.outbound.Get(rule.Action)
+		return outbound, rule.dynamic
 	}
-	return r.endpoint
+	return endpoint, false
 }
 
-func (r *Router) validateRequest(request *dns.Msg) (resp *dns.Msg) {
+func (r *Router) validateRequest(request *dns.Msg, p *policy) (resp *dns.Msg) {
 	switch {
 	case len(request.Question) == 0:
 		return utils.NewMsgNXDOMAIN(request)
@@ -151,7 +529,7 @@ func (r *Router) validateRequest(request *dns.Msg) (resp *dns.Msg) {
 		// Refuse requests of type ANY (anti-DDOS measure).
 		return utils.NewMsgNOTIMPLEMENTED(request)
 	case request.Question[0].Qtype == dns.TypeAAAA:
-		if r.options.BlockAAAA {
+		if p.blockAAAA {
 			return utils.NewMsgNXDOMAIN(request)
 		}
 	// case p.recDetector.check(d.Req):
@@ -166,15 +544,15 @@ func (r *Router) validateRequest(request *dns.Msg) (resp *dns.Msg) {
 
 func (r *Router) isForbiddenARPA(req *dns.Msg) bool {
 	q := req.Question[0]
-	// 处理客户端反查
+	// 处理客户端反查，本地区域内的名称由本地区域应答
 	if q.Qtype == dns.TypePTR {
-		return true
+		return !r.zones.Contains(q.Name)
 	}
 	return false
 }
 
-func (r *Router) rewrite(req *dns.Msg) *dns.Msg {
-	if rewrite, ok := r.rewriter.Rewrite(req.Question[0].Name, req.Question[0].Qtype); ok {
+func (r *Router) rewrite(req *dns.Msg, p *policy) *dns.Msg {
+	if rewrite, ok := p.rewriter.Rewrite(req.Question[0].Name, req.Question[0].Qtype); ok {
 		rewrite.SetReply(req)
 		rewrite.Authoritative = true
 		rewrite.RecursionAvailable = true
@@ -183,28 +561,10 @@ func (r *Router) rewrite(req *dns.Msg) *dns.Msg {
 	return nil
 }
 
-// processECS 添加 EDNS Client Subnet 到 DNS 请求中
-func (r *Router) processECS(in *dns.Msg, cliIP net.IP) (reqECS *net.IPNet) {
-	// 检查消息中是否已有有效 ECS 选项（避免覆盖）
-	if ecs, _ := ecsFromMsg(in); ecs != nil {
-		if ones, _ := ecs.Mask.Size(); ones != 0 {
-			return ecs
-		}
+// block 策略启用拦截且命中时返回拦截应答
+func (r *Router) block(req *dns.Msg, p *policy) (*dns.Msg, bool) {
+	if !p.filter {
+		return nil, false
 	}
-
-	// 解析客户端 IP（处理 IPv4/IPv6）
-	cliAddr, ok := netip.AddrFromSlice(cliIP)
-	if !ok {
-		slog.Debug("parse client IP failed", "ip", cliIP)
-		return nil
-	}
-
-	slog.Debug("process ECS", "ip", cliIP, "cliAddr", cliAddr)
-	if !util.IsSpecialPurpose(cliAddr) {
-		// A Stub Resolver MUST set SCOPE PREFIX-LENGTH to 0.  See RFC 7871
-		// Section 6.
-		return setECS(in, cliIP, 0)
-	}
-
-	return nil
+	return r.filter.Block(req)
 }
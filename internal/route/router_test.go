@@ -0,0 +1,207 @@
+package route
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/taodev/godns/internal/adapter"
+	"github.com/taodev/godns/internal/cache"
+	"github.com/taodev/godns/internal/filter"
+	"github.com/taodev/godns/internal/rewrite"
+	"github.com/taodev/godns/internal/zone"
+)
+
+// testOutbound 由 handler 应答的上游，记录查询次数
+type testOutbound struct {
+	tag     string
+	handler func(req *dns.Msg) *dns.Msg
+	queries atomic.Int32
+}
+
+func (o *testOutbound) Tag() string {
+	return o.tag
+}
+
+func (o *testOutbound) Exchange(req *dns.Msg) (*dns.Msg, time.Duration, error) {
+	o.queries.Add(1)
+	resp := o.handler(req)
+	rcode := resp.Rcode
+	resp.SetReply(req)
+	resp.Rcode = rcode
+	return resp, 0, nil
+}
+
+func (o *testOutbound) Close() {
+}
+
+type testManager map[string]adapter.Outbound
+
+func (m testManager) Get(tag string) (adapter.Outbound, bool) {
+	o, ok := m[tag]
+	return o, ok
+}
+
+func (m testManager) Healthy(tag string) bool {
+	return true
+}
+
+// upstreamRR 上游以固定记录应答，没有记录时返回 NXDOMAIN
+func upstreamRR(records map[string]string) func(req *dns.Msg) *dns.Msg {
+	return func(req *dns.Msg) *dns.Msg {
+		resp := new(dns.Msg)
+		q := req.Question[0]
+		if s, ok := records[dns.CanonicalName(q.Name)+" "+dns.TypeToString[q.Qtype]]; ok {
+			rr, err := dns.NewRR(s)
+			if err != nil {
+				panic(err)
+			}
+			resp.Answer = append(resp.Answer, rr)
+			return resp
+		}
+		soa, _ := dns.NewRR(". 3600 IN SOA a.root. h.root. 1 1800 900 604800 300")
+		resp.Rcode = dns.RcodeNameError
+		resp.Ns = append(resp.Ns, soa)
+		return resp
+	}
+}
+
+// newTestRouter 使用单个上游与可选的本地区域（区域名 -> 区域文件内容）
+func newTestRouter(t *testing.T, upstream *testOutbound, zones map[string]string, cacheOpts *cache.Options) *Router {
+	t.Helper()
+	var zoneOpts []zone.Options
+	for name, text := range zones {
+		file := filepath.Join(t.TempDir(), "zone")
+		if err := os.WriteFile(file, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		zoneOpts = append(zoneOpts, zone.Options{Name: name, File: file})
+	}
+	zs, err := zone.New(zoneOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cacheOpts == nil {
+		cacheOpts = &cache.Options{}
+	}
+	cacheOpts.MaxCounters, cacheOpts.MaxCost, cacheOpts.BufferItems = 1000, 1<<20, 64
+	cacheOpts.MaxTTL = max(cacheOpts.MaxTTL, 24*time.Hour)
+	cacheOpts.NegativeTTL = max(cacheOpts.NegativeTTL, time.Hour)
+	c, err := cache.New(cacheOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	rewriter, err := rewrite.NewRewriter(rewrite.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := filter.New(filter.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(&Options{Default: upstream.tag}, testManager{upstream.tag: upstream}, rewriter, f, zs, c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func query(t *testing.T, r *Router, name string, qtype uint16, rd bool) *dns.Msg {
+	t.Helper()
+	req := new(dns.Msg)
+	req.SetQuestion(name, qtype)
+	req.RecursionDesired = rd
+	resp, err := r.Exchange(req, "udp", "192.0.2.100")
+	if err != nil {
+		t.Fatalf("exchange %s: %v", name, err)
+	}
+	return resp
+}
+
+// 缓存中的应答 TTL 随时间递减，不被重写配置提高
+func TestCachedTTLCountsDown(t *testing.T) {
+	upstream := &testOutbound{tag: "up", handler: upstreamRR(map[string]string{
+		"www.example.com. A": "www.example.com. 300 IN A 192.0.2.1",
+	})}
+	r := newTestRouter(t, upstream, nil, nil)
+
+	if ttl := query(t, r, "www.example.com.", dns.TypeA, true).Answer[0].Header().Ttl; ttl != 300 {
+		t.Fatalf("fresh ttl = %d, want 300", ttl)
+	}
+	// 缓存写入为异步操作
+	time.Sleep(100 * time.Millisecond)
+	first := query(t, r, "www.example.com.", dns.TypeA, true).Answer[0].Header().Ttl
+	time.Sleep(2100 * time.Millisecond)
+	second := query(t, r, "www.example.com.", dns.TypeA, true).Answer[0].Header().Ttl
+	if n := upstream.queries.Load(); n != 1 {
+		t.Fatalf("upstream queries = %d, want 1", n)
+	}
+	if first > 300 || second >= first || first-second > 3 {
+		t.Fatalf("cached ttl %d then %d, want a countdown from at most 300", first, second)
+	}
+
+	// 否定应答的 SOA TTL 为 SOA TTL 与 MINIMUM 中较小者（RFC 2308）
+	resp := query(t, r, "nx.example.com.", dns.TypeA, true)
+	if resp.Rcode != dns.RcodeNameError || len(resp.Ns) != 1 || resp.Ns[0].Header().Ttl != 300 {
+		t.Fatalf("negative reply = %v, want NXDOMAIN with SOA TTL 300", resp)
+	}
+}
+
+// cache.min-ttl 在写入时提高 TTL，新应答与缓存应答从同一 TTL 开始递减
+func TestCacheMinTTLFloor(t *testing.T) {
+	upstream := &testOutbound{tag: "up", handler: upstreamRR(map[string]string{
+		"short.example.com. A": "short.example.com. 5 IN A 192.0.2.2",
+	})}
+	r := newTestRouter(t, upstream, nil, &cache.Options{MinTTL: time.Minute})
+	if ttl := query(t, r, "short.example.com.", dns.TypeA, true).Answer[0].Header().Ttl; ttl != 60 {
+		t.Fatalf("fresh ttl = %d, want 60", ttl)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if ttl := query(t, r, "short.example.com.", dns.TypeA, true).Answer[0].Header().Ttl; ttl > 60 || ttl < 58 {
+		t.Fatalf("cached ttl = %d, want at most 60", ttl)
+	}
+}
+
+// 上游刷新失败后 stale-recheck 时长内直接返回过期应答，不再查询上游（RFC 8767）
+func TestStaleRecheck(t *testing.T) {
+	var failing atomic.Bool
+	answer := upstreamRR(map[string]string{
+		"www.example.com. A": "www.example.com. 1 IN A 192.0.2.1",
+	})
+	upstream := &testOutbound{tag: "up", handler: func(req *dns.Msg) *dns.Msg {
+		if failing.Load() {
+			resp := new(dns.Msg)
+			resp.Rcode = dns.RcodeServerFailure
+			return resp
+		}
+		return answer(req)
+	}}
+	r := newTestRouter(t, upstream, nil, &cache.Options{
+		MaxStale:     time.Hour,
+		StaleTTL:     30 * time.Second,
+		StaleTimeout: time.Second,
+		StaleRecheck: time.Hour,
+	})
+	query(t, r, "www.example.com.", dns.TypeA, true)
+	// 等待条目过期
+	time.Sleep(2100 * time.Millisecond)
+	failing.Store(true)
+
+	for i := range 3 {
+		start := time.Now()
+		resp := query(t, r, "www.example.com.", dns.TypeA, true)
+		if len(resp.Answer) != 1 || resp.Answer[0].Header().Ttl != 30 {
+			t.Fatalf("query %d: reply = %v, want stale answer with TTL 30", i, resp)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Fatalf("query %d: stale answer took %v", i, elapsed)
+		}
+	}
+	if n := upstream.queries.Load(); n != 2 {
+		t.Fatalf("upstream queries = %d, want 2", n)
+	}
+}
@@ -0,0 +1,339 @@
+package route
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/taodev/pkg/geodb"
+)
+
+// Context 路由规则匹配上下文
+type Context struct {
+	// 域名，小写且不带末尾的点
+	Domain  string
+	Qtype   uint16
+	Client  netip.Addr
+	Inbound string
+	Time    time.Time
+
+	geo geodb.Context
+}
+
+func NewContext(domain string, qtype uint16, client netip.Addr, inbound string) *Context {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	return &Context{
+		Domain:  domain,
+		Qtype:   qtype,
+		Client:  client.Unmap(),
+		Inbound: inbound,
+		Time:    time.Now(),
+		geo:     geodb.Context{Domain: domain},
+	}
+}
+
+type expr interface {
+	eval(ctx *Context) bool
+}
+
+// Rule 路由规则，形如 action(cond, ...)，多个条件为或关系
+type Rule struct {
+	Action string
+	cond   expr
+	// 条件依赖客户端、入站或时间，结果不能按域名共享缓存
+	dynamic bool
+}
+
+func (r *Rule) Match(ctx *Context) bool {
+	return r.cond.eval(ctx)
+}
+
+// ParseRule 解析路由规则，条件支持 &&、||、! 与括号组合：
+//
+//	full("a.com")、suffix("a.com")、keyword("google")、regex(`^ad\d+\.`)、geosite("cn")  域名
+//	qtype("A", "HTTPS")                   查询类型
+//	cidr("10.0.0.0/8", "192.168.1.2")     客户端 IP
+//	inbound("udp", "stcp")                入站类型
+//	time("08:00-18:00", "22:00-06:00")    本地时间段，结束时间早于开始时间表示跨天
+func ParseRule(code string) (*Rule, error) {
+	node, err := parser.ParseExpr(code)
+	if err != nil {
+		return nil, err
+	}
+	call, ok := node.(*ast.CallExpr)
+	if !ok {
+		return nil, fmt.Errorf("must like action(...), code: %s", code)
+	}
+	action, ok := call.Fun.(*ast.Ident)
+	if !ok {
+		return nil, fmt.Errorf("action must be ident")
+	}
+	if len(call.Args) == 0 {
+		return nil, fmt.Errorf("must have at least one condition")
+	}
+	rule := &Rule{Action: action.Name}
+	for _, arg := range call.Args {
+		cond, err := rule.build(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", code, err)
+		}
+		if rule.cond == nil {
+			rule.cond = cond
+		} else {
+			rule.cond = &orExpr{left: rule.cond, right: cond}
+		}
+	}
+	return rule, nil
+}
+
+func (r *Rule) build(node ast.Expr) (expr, error) {
+	switch v := node.(type) {
+	case *ast.CallExpr:
+		fn, ok := v.Fun.(*ast.Ident)
+		if !ok {
+			return nil, fmt.Errorf("unsupported function expression")
+		}
+		args, err := stringArgs(v.Args)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name, err)
+		}
+		return r.buildCall(fn.Name, args)
+	case *ast.UnaryExpr:
+		if v.Op == token.NOT {
+			e, err := r.build(v.X)
+			if err != nil {
+				return nil, err
+			}
+			return &notExpr{expr: e}, nil
+		}
+	case *ast.BinaryExpr:
+		left, err := r.build(v.X)
+		if err != nil {
+			return nil, err
+		}
+		right, err := r.build(v.Y)
+		if err != nil {
+			return nil, err
+		}
+		switch v.Op {
+		case token.LAND:
+			return &andExpr{left: left, right: right}, nil
+		case token.LOR:
+			return &orExpr{left: left, right: right}, nil
+		}
+		return nil, fmt.Errorf("unsupported logical operator: %s", v.Op)
+	case *ast.ParenExpr:
+		return r.build(v.X)
+	}
+	return nil, fmt.Errorf("unsupported expression type: %T", node)
+}
+
+func (r *Rule) buildCall(name string, args []string) (expr, error) {
+	lower := make([]string, len(args))
+	for i, arg := range args {
+		lower[i] = strings.ToLower(arg)
+	}
+	switch name {
+	case geodb.DomainKeyFull:
+		return &domainExpr{expr: &geodb.FullExpr{Values: lower}}, nil
+	case geodb.DomainKeyKeyword:
+		return &domainExpr{expr: &geodb.KeywordExpr{Values: lower}}, nil
+	case geodb.DomainKeySuffix:
+		return &domainExpr{expr: &geodb.SuffixExpr{Values: lower}}, nil
+	case geodb.DomainKeyRegex:
+		var e geodb.RegexExpr
+		for _, arg := range args {
+			re, err := regexp.Compile(arg)
+			if err != nil {
+				return nil, err
+			}
+			e.Regexes = append(e.Regexes, re)
+		}
+		return &domainExpr{expr: &e}, nil
+	case geodb.DomainKeyGeoSite:
+		var e geodb.GeoSiteExpr
+		for _, tag := range lower {
+			matcher, err := geodb.Site(geodb.GeoSitePath, tag)
+			if err != nil {
+				return nil, fmt.Errorf("geosite:%s - %w", tag, err)
+			}
+			e.Matcher = append(e.Matcher, matcher.(*geodb.DomainMatcher))
+		}
+		return &domainExpr{expr: &e}, nil
+	case "qtype":
+		var e qtypeExpr
+		for _, arg := range args {
+			qtype, ok := dns.StringToType[strings.ToUpper(arg)]
+			if !ok {
+				return nil, fmt.Errorf("unknown qtype: %s", arg)
+			}
+			e.types = append(e.types, qtype)
+		}
+		return &e, nil
+	case "cidr":
+		var e cidrExpr
+		for _, arg := range args {
+			prefix, err := netip.ParsePrefix(arg)
+			if err != nil {
+				addr, addrErr := netip.ParseAddr(arg)
+				if addrErr != nil {
+					return nil, err
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			e.prefixes = append(e.prefixes, prefix.Masked())
+		}
+		r.dynamic = true
+		return &e, nil
+	case "inbound":
+		r.dynamic = true
+		return &inboundExpr{inbounds: lower}, nil
+	case "time":
+		var e timeExpr
+		for _, arg := range args {
+			span, err := parseTimeSpan(arg)
+			if err != nil {
+				return nil, err
+			}
+			e.spans = append(e.spans, span)
+		}
+		r.dynamic = true
+		return &e, nil
+	}
+	return nil, fmt.Errorf("unsupported function expression: %s", name)
+}
+
+func stringArgs(args []ast.Expr) ([]string, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("must have at least one string argument")
+	}
+	values := make([]string, 0, len(args))
+	for _, arg := range args {
+		lit, ok := arg.(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			return nil, fmt.Errorf("must be string literal")
+		}
+		s, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, s)
+	}
+	return values, nil
+}
+
+// domainExpr 复用 geodb 的域名条件
+type domainExpr struct {
+	expr geodb.Expr
+}
+
+func (e *domainExpr) eval(ctx *Context) bool {
+	return e.expr.Eval(&ctx.geo)
+}
+
+type qtypeExpr struct {
+	types []uint16
+}
+
+func (e *qtypeExpr) eval(ctx *Context) bool {
+	return slices.Contains(e.types, ctx.Qtype)
+}
+
+type cidrExpr struct {
+	prefixes []netip.Prefix
+}
+
+func (e *cidrExpr) eval(ctx *Context) bool {
+	for _, prefix := range e.prefixes {
+		if prefix.Contains(ctx.Client) {
+			return true
+		}
+	}
+	return false
+}
+
+type inboundExpr struct {
+	inbounds []string
+}
+
+func (e *inboundExpr) eval(ctx *Context) bool {
+	return slices.Contains(e.inbounds, ctx.Inbound)
+}
+
+// timeSpan 一天内的时间段，单位为分钟
+type timeSpan struct {
+	start, end int
+}
+
+func parseTimeSpan(s string) (span timeSpan, err error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return span, fmt.Errorf("invalid time span: %s", s)
+	}
+	if span.start, err = parseClock(from); err != nil {
+		return span, err
+	}
+	if span.end, err = parseClock(to); err != nil {
+		return span, err
+	}
+	return span, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+type timeExpr struct {
+	spans []timeSpan
+}
+
+func (e *timeExpr) eval(ctx *Context) bool {
+	now := ctx.Time.Hour()*60 + ctx.Time.Minute()
+	for _, span := range e.spans {
+		if span.start <= span.end {
+			if now >= span.start && now < span.end {
+				return true
+			}
+		} else if now >= span.start || now < span.end {
+			// 跨天
+			return true
+		}
+	}
+	return false
+}
+
+type notExpr struct {
+	expr expr
+}
+
+func (e *notExpr) eval(ctx *Context) bool {
+	return !e.expr.eval(ctx)
+}
+
+type andExpr struct {
+	left, right expr
+}
+
+func (e *andExpr) eval(ctx *Context) bool {
+	return e.left.eval(ctx) && e.right.eval(ctx)
+}
+
+type orExpr struct {
+	left, right expr
+}
+
+func (e *orExpr) eval(ctx *Context) bool {
+	return e.left.eval(ctx) || e.right.eval(ctx)
+}
@@ -2,6 +2,7 @@ package route
 
 import (
 	"net"
+	"net/netip"
 
 	"github.com/miekg/dns"
 )
@@ -12,78 +13,83 @@ const (
 )
 
 // ecsFromMsg returns the subnet from EDNS Client Subnet option of m if any.
-func ecsFromMsg(m *dns.Msg) (subnet *net.IPNet, scope int) {
+func ecsFromMsg(m *dns.Msg) (subnet netip.Prefix, scope int) {
 	opt := m.IsEdns0()
 	if opt == nil {
-		return nil, 0
+		return netip.Prefix{}, 0
 	}
 
-	var ip net.IP
-	var mask net.IPMask
 	for _, e := range opt.Option {
 		sn, ok := e.(*dns.EDNS0_SUBNET)
 		if !ok {
 			continue
 		}
 
+		var bits int
 		switch sn.Family {
 		case 1:
-			ip = sn.Address.To4()
-			mask = net.CIDRMask(int(sn.SourceNetmask), IPv4BitLen)
+			bits = IPv4BitLen
 		case 2:
-			ip = sn.Address
-			mask = net.CIDRMask(int(sn.SourceNetmask), IPv6BitLen)
+			bits = IPv6BitLen
 		default:
 			continue
 		}
 
-		return &net.IPNet{IP: ip, Mask: mask}, int(sn.SourceScope)
+		addr, ok := netip.AddrFromSlice(sn.Address)
+		if !ok || int(sn.SourceNetmask) > bits {
+			continue
+		}
+		if bits == IPv4BitLen {
+			addr = addr.Unmap()
+		}
+		subnet, err := addr.Prefix(int(sn.SourceNetmask))
+		if err != nil {
+			continue
+		}
+
+		return subnet, int(sn.SourceScope)
 	}
 
-	return nil, 0
+	return netip.Prefix{}, 0
 }
 
-// setECS sets the EDNS client subnet option based on ip and scope into m.  It
-// returns masked IP and mask length.
-func setECS(m *dns.Msg, ip net.IP, scope uint8) (subnet *net.IPNet) {
-	const (
-		// defaultECSv4 is the default length of network mask for IPv4 address
-		// in ECS option.
-		defaultECSv4 = 24
+// removeECS removes all EDNS Client Subnet options from m.
+func removeECS(m *dns.Msg) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return
+	}
 
-		// defaultECSv6 is the default length of network mask for IPv6 address
-		// in ECS.  The size of 7 octets is chosen as a reasonable minimum since
-		// at least Google's public DNS refuses requests containing the options
-		// with longer network masks.
-		defaultECSv6 = 56
-	)
+	options := opt.Option[:0]
+	for _, e := range opt.Option {
+		if e.Option() != dns.EDNS0SUBNET {
+			options = append(options, e)
+		}
+	}
+	opt.Option = options
+}
 
+// setECS replaces the EDNS Client Subnet option of m with subnet.  A Stub
+// Resolver MUST set SCOPE PREFIX-LENGTH to 0, see RFC 7871 Section 6.
+func setECS(m *dns.Msg, subnet netip.Prefix) {
 	e := &dns.EDNS0_SUBNET{
-		Code:        dns.EDNS0SUBNET,
-		SourceScope: scope,
+		Code:          dns.EDNS0SUBNET,
+		SourceNetmask: uint8(subnet.Bits()),
+		Address:       subnet.Addr().AsSlice(),
 	}
-
-	subnet = &net.IPNet{}
-	if ip4 := ip.To4(); ip4 != nil {
+	if subnet.Addr().Is4() {
 		e.Family = 1
-		e.SourceNetmask = defaultECSv4
-		subnet.Mask = net.CIDRMask(defaultECSv4, IPv4BitLen)
-		ip = ip4
 	} else {
-		// Assume the IP address has already been validated.
 		e.Family = 2
-		e.SourceNetmask = defaultECSv6
-		subnet.Mask = net.CIDRMask(defaultECSv6, IPv6BitLen)
 	}
-	subnet.IP = ip.Mask(subnet.Mask)
-	e.Address = subnet.IP
 
 	// If OPT record already exists so just add EDNS option inside it.  Note
 	// that servers may return FORMERR if they meet several OPT RRs.
 	if opt := m.IsEdns0(); opt != nil {
+		removeECS(m)
 		opt.Option = append(opt.Option, e)
 
-		return subnet
+		return
 	}
 
 	// Create an OPT record and add EDNS option inside it.
@@ -96,6 +102,4 @@ func setECS(m *dns.Msg, ip net.IP, scope uint8) (subnet *net.IPNet) {
 	}
 	o.SetUDPSize(4096)
 	m.Extra = append(m.Extra, o)
-
-	return subnet
 }
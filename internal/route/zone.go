@@ -0,0 +1,73 @@
+package route
+
+import (
+	"log/slog"
+	"net/netip"
+
+	"github.com/miekg/dns"
+	"github.com/taodev/godns/internal/querylog"
+)
+
+// 本地区域应答中区域外目标的最大解析深度
+const maxZoneChase = 8
+
+// resolveZone 客户端期望递归（RD）时，区域外的 CNAME/DNAME 目标与委派的子区域通过路由解析；
+// 未设置 RD 时按权威服务器的方式原样返回
+func (r *Router) resolveZone(request, local *dns.Msg, p *policy, inbound string, addr netip.Addr, ip string, depth int) (resp *dns.Msg, source, outboundTag string, err error) {
+	q := request.Question[0]
+	target, ok := pendingName(local, q.Name, q.Qtype)
+	if !ok || !request.RecursionDesired || depth >= maxZoneChase {
+		return local, querylog.SourceZone, "zone", nil
+	}
+	if target == dns.CanonicalName(q.Name) {
+		// 查询名位于委派的子区域，由上游解析
+		return r.upstream(request, p, inbound, addr, ip)
+	}
+	sub := request.Copy()
+	sub.Question[0].Name = target
+	resp, _, _, err = r.answer(sub, p, inbound, addr, ip, depth+1)
+	if err != nil {
+		// 目标解析失败时返回区域内的部分应答
+		slog.Debug("resolve zone target failed", "domain", q.Name, "target", target, "error", err)
+		return local, querylog.SourceZone, "zone", nil
+	}
+	// 授权区与附加区（委派的 NS 与胶水记录）改用目标的应答
+	local.Answer = append(local.Answer, resp.Answer...)
+	local.Ns = resp.Ns
+	local.Extra = nil
+	local.Rcode = resp.Rcode
+	return local, querylog.SourceZone, "zone", nil
+}
+
+// pendingName 区域应答未完成时返回待解析的名称：CNAME 链指向区域外或委派的子区域，
+// 或查询名本身位于委派的子区域
+func pendingName(resp *dns.Msg, qname string, qtype uint16) (string, bool) {
+	if resp.Rcode != dns.RcodeSuccess || qtype == dns.TypeCNAME || qtype == dns.TypeANY {
+		return "", false
+	}
+	name := dns.CanonicalName(qname)
+	for _, rr := range resp.Answer {
+		if cname, ok := rr.(*dns.CNAME); ok && dns.CanonicalName(cname.Hdr.Name) == name {
+			name = dns.CanonicalName(cname.Target)
+		}
+	}
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype == qtype && dns.CanonicalName(rr.Header().Name) == name {
+			return "", false
+		}
+	}
+	var referral bool
+	for _, rr := range resp.Ns {
+		switch rr.Header().Rrtype {
+		case dns.TypeSOA:
+			// 区域内的否定应答
+			return "", false
+		case dns.TypeNS:
+			referral = true
+		}
+	}
+	if name == dns.CanonicalName(qname) && !referral {
+		return "", false
+	}
+	return name, true
+}
@@ -0,0 +1,143 @@
+package route
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+const testZone = `$ORIGIN example.
+$TTL 3600
+@    IN SOA   ns.example. hostmaster.example. 1 7200 3600 1209600 300
+@    IN NS    ns.example.
+ns   IN A     192.0.2.53
+www  IN A     192.0.2.1
+sub  IN NS    ns.sub.example.
+ns.sub IN A   192.0.2.54
+c1   IN CNAME www.example.
+ext  IN CNAME www.other.net.
+dn   IN DNAME other.net.
+`
+
+func testMsg(t *testing.T, rcode int, answer, ns []string) *dns.Msg {
+	t.Helper()
+	msg := new(dns.Msg)
+	msg.Rcode = rcode
+	for _, s := range answer {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Answer = append(msg.Answer, rr)
+	}
+	for _, s := range ns {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Ns = append(msg.Ns, rr)
+	}
+	return msg
+}
+
+func TestPendingName(t *testing.T) {
+	const soa = "example. 300 IN SOA ns.example. hostmaster.example. 1 7200 3600 1209600 300"
+	tests := []struct {
+		name    string
+		qname   string
+		qtype   uint16
+		rcode   int
+		answer  []string
+		ns      []string
+		want    string
+		pending bool
+	}{
+		{name: "complete", qname: "www.example.", qtype: dns.TypeA,
+			answer: []string{"www.example. 3600 IN A 192.0.2.1"}},
+		{name: "cname chain complete", qname: "c1.example.", qtype: dns.TypeA,
+			answer: []string{"c1.example. 3600 IN CNAME www.example.", "www.example. 3600 IN A 192.0.2.1"}},
+		{name: "cname out of zone", qname: "ext.example.", qtype: dns.TypeA,
+			answer: []string{"ext.example. 3600 IN CNAME www.other.net."}, want: "www.other.net.", pending: true},
+		{name: "dname out of zone", qname: "www.dn.example.", qtype: dns.TypeA,
+			answer: []string{"dn.example. 3600 IN DNAME other.net.", "www.dn.example. 3600 IN CNAME www.other.net."},
+			want:   "www.other.net.", pending: true},
+		{name: "cname to delegation", qname: "ext.example.", qtype: dns.TypeA,
+			answer: []string{"ext.example. 3600 IN CNAME www.sub.example."},
+			ns:     []string{"sub.example. 3600 IN NS ns.sub.example."}, want: "www.sub.example.", pending: true},
+		{name: "referral", qname: "www.sub.example.", qtype: dns.TypeA,
+			ns: []string{"sub.example. 3600 IN NS ns.sub.example."}, want: "www.sub.example.", pending: true},
+		{name: "nodata", qname: "www.example.", qtype: dns.TypeAAAA, ns: []string{soa}},
+		{name: "cname to nodata", qname: "c1.example.", qtype: dns.TypeAAAA,
+			answer: []string{"c1.example. 3600 IN CNAME www.example."}, ns: []string{soa}},
+		{name: "nxdomain", qname: "nope.example.", qtype: dns.TypeA, rcode: dns.RcodeNameError, ns: []string{soa}},
+		{name: "yxdomain", qname: "www.dn.example.", qtype: dns.TypeA, rcode: dns.RcodeYXDomain,
+			answer: []string{"dn.example. 3600 IN DNAME other.net."}},
+		{name: "cname query", qname: "ext.example.", qtype: dns.TypeCNAME,
+			answer: []string{"ext.example. 3600 IN CNAME www.other.net."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := pendingName(testMsg(t, tt.rcode, tt.answer, tt.ns), tt.qname, tt.qtype)
+			if got != tt.want || ok != tt.pending {
+				t.Fatalf("pendingName = %q, %v, want %q, %v", got, ok, tt.want, tt.pending)
+			}
+		})
+	}
+}
+
+// 设置 RD 时区域外的目标与委派的子区域通过上游解析，未设置时返回区域内的应答
+func TestResolveZone(t *testing.T) {
+	upstream := &testOutbound{tag: "up", handler: upstreamRR(map[string]string{
+		"www.other.net. A":   "www.other.net. 300 IN A 198.51.100.1",
+		"mail.other.net. A":  "mail.other.net. 300 IN A 198.51.100.3",
+		"www.sub.example. A": "www.sub.example. 300 IN A 198.51.100.2",
+	})}
+	r := newTestRouter(t, upstream, map[string]string{"example.": testZone}, nil)
+
+	tests := []struct {
+		name    string
+		qname   string
+		qtype   uint16
+		rd      bool
+		rcode   int
+		answer  []string
+		queries int32
+	}{
+		{name: "in zone", qname: "c1.example.", qtype: dns.TypeA, rd: true,
+			answer: []string{"c1.example. CNAME www.example.", "www.example. A 192.0.2.1"}},
+		{name: "cname without rd", qname: "ext.example.", qtype: dns.TypeA,
+			answer: []string{"ext.example. CNAME www.other.net."}},
+		{name: "cname with rd", qname: "ext.example.", qtype: dns.TypeA, rd: true, queries: 1,
+			answer: []string{"ext.example. CNAME www.other.net.", "www.other.net. A 198.51.100.1"}},
+		{name: "dname with rd", qname: "mail.dn.example.", qtype: dns.TypeA, rd: true, queries: 1,
+			answer: []string{"dn.example. DNAME other.net.", "mail.dn.example. CNAME mail.other.net.", "mail.other.net. A 198.51.100.3"}},
+		{name: "cname target nxdomain", qname: "ext.example.", qtype: dns.TypeAAAA, rd: true, rcode: dns.RcodeNameError, queries: 1,
+			answer: []string{"ext.example. CNAME www.other.net."}},
+		{name: "referral without rd", qname: "www.sub.example.", qtype: dns.TypeA},
+		{name: "delegation with rd", qname: "www.sub.example.", qtype: dns.TypeA, rd: true, queries: 1,
+			answer: []string{"www.sub.example. A 198.51.100.2"}},
+		// 区域顶点的 DS 属于父区域，由上游解析
+		{name: "ds at apex", qname: "example.", qtype: dns.TypeDS, rd: true, rcode: dns.RcodeNameError, queries: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := upstream.queries.Load()
+			resp := query(t, r, tt.qname, tt.qtype, tt.rd)
+			if resp.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			var answer []string
+			for _, rr := range resp.Answer {
+				fields := strings.Fields(rr.String())
+				answer = append(answer, fields[0]+" "+fields[3]+" "+strings.Join(fields[4:], " "))
+			}
+			if got := strings.Join(answer, "\n"); got != strings.Join(tt.answer, "\n") {
+				t.Errorf("answer:\n%s\nwant:\n%s", got, strings.Join(tt.answer, "\n"))
+			}
+			if n := upstream.queries.Load() - before; n != tt.queries {
+				t.Errorf("upstream queries = %d, want %d", n, tt.queries)
+			}
+		})
+	}
+}
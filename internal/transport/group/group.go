@@ -0,0 +1,287 @@
+package group
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/taodev/godns/internal/adapter"
+	"github.com/taodev/pkg/defaults"
+)
+
+// 负载均衡策略
+const (
+	// 按顺序使用，失败时切换到下一个
+	StrategyFailover = "failover"
+	// 轮询
+	StrategyRoundRobin = "round-robin"
+	// 按权重随机
+	StrategyWeighted = "weighted"
+	// 优先使用延迟最低的上游
+	StrategyLatency = "lowest-latency"
+	// 同时请求所有上游，最快的响应胜出
+	StrategyParallel = "parallel"
+)
+
+const (
+	// 延迟滑动平均的权重
+	rttDecay = 0.3
+	// 失败时记录的惩罚延迟
+	failurePenalty = 10 * time.Second
+)
+
+type Options struct {
+	// 负载均衡策略（failover/round-robin/weighted/lowest-latency/parallel）
+	Strategy string `yaml:"strategy" default:"failover"`
+	// 上游标签
+	Outbounds []string `yaml:"outbounds"`
+	// 权重，与 outbounds 一一对应（仅 weighted 有效）
+	Weights []int `yaml:"weights"`
+}
+
+type Group struct {
+	tag     string
+	options *Options
+	manager adapter.OutboundManager
+
+	next        atomic.Uint32
+	totalWeight int
+
+	access sync.RWMutex
+	rtts   map[string]time.Duration
+}
+
+func New(tag string, options *Options, manager adapter.OutboundManager) (*Group, error) {
+	if err := defaults.Set(options); err != nil {
+		return nil, err
+	}
+	if len(options.Outbounds) == 0 {
+		return nil, fmt.Errorf("group %s: outbounds is empty", tag)
+	}
+	for _, member := range options.Outbounds {
+		if member == tag {
+			return nil, fmt.Errorf("group %s: must not contain itself", tag)
+		}
+	}
+	g := &Group{
+		tag:     tag,
+		options: options,
+		manager: manager,
+		rtts:    make(map[string]time.Duration),
+	}
+	switch options.Strategy {
+	case StrategyFailover, StrategyRoundRobin, StrategyLatency, StrategyParallel:
+	case StrategyWeighted:
+		if len(options.Weights) != len(options.Outbounds) {
+			return nil, fmt.Errorf("group %s: weights must match outbounds", tag)
+		}
+		for _, w := range options.Weights {
+			if w < 0 {
+				return nil, fmt.Errorf("group %s: weight must not be negative", tag)
+			}
+			g.totalWeight += w
+		}
+		if g.totalWeight == 0 {
+			return nil, fmt.Errorf("group %s: total weight is zero", tag)
+		}
+	default:
+		return nil, fmt.Errorf("group %s: unknown strategy %s", tag, options.Strategy)
+	}
+	return g, nil
+}
+
+// Check 检查上游组成员：成员必须是已定义的上游或组，组之间不能循环引用（如 a→b→a）
+func Check(groups map[string]*Options, exists func(tag string) bool) error {
+	const (
+		visiting = iota + 1
+		done
+	)
+	state := make(map[string]int, len(groups))
+	var visit func(tag string, path []string) error
+	visit = func(tag string, path []string) error {
+		switch state[tag] {
+		case visiting:
+			return fmt.Errorf("group %s: cycle %s", tag, strings.Join(append(path, tag), " -> "))
+		case done:
+			return nil
+		}
+		state[tag] = visiting
+		path = append(path, tag)
+		for _, member := range groups[tag].Outbounds {
+			if !exists(member) {
+				return fmt.Errorf("group %s: outbound %s not found", tag, member)
+			}
+			if _, ok := groups[member]; ok {
+				if err := visit(member, path); err != nil {
+					return err
+				}
+			}
+		}
+		state[tag] = done
+		return nil
+	}
+	tags := make([]string, 0, len(groups))
+	for tag := range groups {
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+	for _, tag := range tags {
+		if err := visit(tag, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Group) Tag() string {
+	return g.tag
+}
+
+func (g *Group) Exchange(in *dns.Msg) (resp *dns.Msg, rtt time.Duration, err error) {
+	if g.options.Strategy == StrategyParallel {
+		return g.exchangeParallel(in)
+	}
+
+	now := time.Now()
+	for _, tag := range g.order() {
+		outbound, ok := g.manager.Get(tag)
+		if !ok {
+			err = fmt.Errorf("outbound %s not found", tag)
+			continue
+		}
+		var elapsed time.Duration
+		resp, elapsed, err = outbound.Exchange(in)
+		if err == nil && resp.Rcode == dns.RcodeServerFailure {
+			err = errors.New("server failure")
+		}
+		if err != nil {
+			g.observe(tag, failurePenalty)
+			slog.Debug("group outbound failed", "group", g.tag, "outbound", tag, "error", err)
+			continue
+		}
+		g.observe(tag, elapsed)
+		return resp, time.Since(now), nil
+	}
+	return nil, time.Since(now), err
+}
+
+type result struct {
+	resp *dns.Msg
+	err  error
+}
+
+// exchangeParallel 同时向所有上游发送请求，返回第一个成功的响应
+func (g *Group) exchangeParallel(in *dns.Msg) (resp *dns.Msg, rtt time.Duration, err error) {
+	now := time.Now()
+	// 只向健康的上游发送，全部熔断时才全部尝试
+	tags := g.options.Outbounds
+	var healthy []string
+	for _, tag := range tags {
+		if g.manager.Healthy(tag) {
+			healthy = append(healthy, tag)
+		}
+	}
+	if len(healthy) > 0 {
+		tags = healthy
+	}
+	ch := make(chan result, len(tags))
+	for _, tag := range tags {
+		outbound, ok := g.manager.Get(tag)
+		if !ok {
+			ch <- result{err: fmt.Errorf("outbound %s not found", tag)}
+			continue
+		}
+		go func(tag string, req *dns.Msg) {
+			resp, elapsed, err := outbound.Exchange(req)
+			if err == nil && resp.Rcode == dns.RcodeServerFailure {
+				err = errors.New("server failure")
+			}
+			if err != nil {
+				g.observe(tag, failurePenalty)
+			} else {
+				g.observe(tag, elapsed)
+			}
+			ch <- result{resp: resp, err: err}
+		}(tag, in.Copy())
+	}
+	for range tags {
+		r := <-ch
+		if r.err == nil {
+			return r.resp, time.Since(now), nil
+		}
+		err = r.err
+	}
+	return nil, time.Since(now), err
+}
+
+// order 根据策略返回本次请求尝试上游的顺序
+func (g *Group) order() []string {
+	tags := g.options.Outbounds
+	n := len(tags)
+	ordered := make([]string, 0, n)
+	first := 0
+	switch g.options.Strategy {
+	case StrategyRoundRobin:
+		first = int(g.next.Add(1)-1) % n
+	case StrategyWeighted:
+		r := rand.IntN(g.totalWeight)
+		for i, w := range g.options.Weights {
+			if r < w {
+				first = i
+				break
+			}
+			r -= w
+		}
+	case StrategyLatency:
+		g.access.RLock()
+		best := time.Duration(-1)
+		for i, tag := range tags {
+			// 尚无测量数据的上游优先探测
+			if rtt := g.rtts[tag]; best < 0 || rtt < best {
+				best, first = rtt, i
+			}
+		}
+		g.access.RUnlock()
+	}
+	// 健康的上游优先，熔断的上游仅作为最后的选择
+	var unhealthy []string
+	for i := range n {
+		tag := tags[(first+i)%n]
+		if g.manager.Healthy(tag) {
+			ordered = append(ordered, tag)
+		} else {
+			unhealthy = append(unhealthy, tag)
+		}
+	}
+	return append(ordered, unhealthy...)
+}
+
+// Healthy 任一成员健康即视为健康
+func (g *Group) Healthy() bool {
+	for _, tag := range g.options.Outbounds {
+		if g.manager.Healthy(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// observe 记录上游延迟（指数滑动平均）
+func (g *Group) observe(tag string, rtt time.Duration) {
+	g.access.Lock()
+	defer g.access.Unlock()
+	if prev, ok := g.rtts[tag]; ok {
+		rtt = time.Duration(float64(prev)*(1-rttDecay) + float64(rtt)*rttDecay)
+	}
+	g.rtts[tag] = rtt
+}
+
+func (g *Group) Close() {
+}
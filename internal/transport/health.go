@@ -0,0 +1,209 @@
+package transport
+
+import (
+	"errors"
+	"log/slog"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/taodev/godns/internal/adapter"
+	"github.com/taodev/godns/internal/metrics"
+	"github.com/taodev/godns/internal/transport/group"
+)
+
+var errServerFailure = errors.New("server failure")
+
+// 健康检查配置
+type HealthOptions struct {
+	// 探测间隔，0 表示不主动探测
+	Interval time.Duration `yaml:"interval" default:"30s"`
+	// 探测域名
+	Probe string `yaml:"probe" default:"."`
+	// 探测记录类型
+	ProbeType string `yaml:"probe-type" default:"NS"`
+	// 连续失败（连接错误或超时，主动探测时包括 SERVFAIL）多少次后熔断
+	FailureThreshold int `yaml:"failure-threshold" default:"3"`
+	// 熔断后连续成功多少次恢复
+	SuccessThreshold int `yaml:"success-threshold" default:"1"`
+	// 熔断后多久允许真实请求试探（半开状态）
+	Cooldown time.Duration `yaml:"cooldown" default:"30s"`
+}
+
+// healthReporter 由自带健康信号的上游实现（如 DoH 连接池）
+type healthReporter interface {
+	Healthy() bool
+}
+
+// HealthStatus 上游健康状态
+type HealthStatus struct {
+	Healthy  bool      `json:"healthy"`
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"opened_at,omitzero"`
+	LastErr  string    `json:"last_error,omitempty"`
+}
+
+// checkedOutbound 为上游附加熔断器，统计每次 Exchange 的结果
+type checkedOutbound struct {
+	adapter.Outbound
+	options *HealthOptions
+
+	access    sync.Mutex
+	failures  int
+	successes int
+	open      bool
+	openedAt  time.Time
+	lastErr   error
+}
+
+func newCheckedOutbound(outbound adapter.Outbound, options *HealthOptions) *checkedOutbound {
+	return &checkedOutbound{
+		Outbound: outbound,
+		options:  options,
+	}
+}
+
+func (o *checkedOutbound) Exchange(in *dns.Msg) (resp *dns.Msg, rtt time.Duration, err error) {
+	resp, rtt, err = o.Outbound.Exchange(in)
+	o.report(resp, rtt, err, false)
+	return resp, rtt, err
+}
+
+// report 统计请求结果，熔断只计传输错误与超时；SERVFAIL 可能来自权威服务器（如 DNSSEC 校验失败），
+// 只有主动探测的 SERVFAIL 计为失败
+func (o *checkedOutbound) report(resp *dns.Msg, rtt time.Duration, err error, probe bool) {
+	if err == nil && resp.Rcode == dns.RcodeServerFailure {
+		metrics.ObserveUpstream(o.Tag(), rtt, errServerFailure)
+		if !probe {
+			// 请求的 SERVFAIL 不影响熔断状态
+			return
+		}
+		err = errServerFailure
+	} else {
+		metrics.ObserveUpstream(o.Tag(), rtt, err)
+	}
+
+	o.access.Lock()
+	defer o.access.Unlock()
+	if err != nil {
+		o.lastErr = err
+		o.successes = 0
+		o.failures++
+		if o.open {
+			// 半开试探失败，重新计时
+			o.openedAt = time.Now()
+		} else if o.failures >= o.options.FailureThreshold {
+			o.open = true
+			o.openedAt = time.Now()
+			slog.Warn("outbound unhealthy", "tag", o.Tag(), "failures", o.failures, "error", err)
+		}
+		return
+	}
+	o.failures = 0
+	o.successes++
+	if o.open && o.successes >= o.options.SuccessThreshold {
+		o.open = false
+		slog.Info("outbound recovered", "tag", o.Tag())
+	}
+}
+
+// Healthy 熔断器关闭且上游自身未报告降级时为健康；
+// 未开启主动探测时，熔断冷却结束后允许请求试探
+func (o *checkedOutbound) Healthy() bool {
+	o.access.Lock()
+	open, openedAt := o.open, o.openedAt
+	o.access.Unlock()
+	if open {
+		return o.options.Interval <= 0 && time.Since(openedAt) >= o.options.Cooldown
+	}
+	if reporter, ok := o.Outbound.(healthReporter); ok {
+		return reporter.Healthy()
+	}
+	return true
+}
+
+func (o *checkedOutbound) Status() HealthStatus {
+	healthy := o.Healthy()
+	o.access.Lock()
+	defer o.access.Unlock()
+	status := HealthStatus{
+		Healthy:  healthy,
+		Failures: o.failures,
+	}
+	if o.open {
+		status.OpenedAt = o.openedAt
+	}
+	if o.lastErr != nil {
+		status.LastErr = o.lastErr.Error()
+	}
+	return status
+}
+
+// probe 主动探测上游
+func (o *checkedOutbound) probe() {
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn(o.options.Probe), dns.StringToType[o.options.ProbeType])
+	resp, rtt, err := o.Outbound.Exchange(req)
+	o.report(resp, rtt, err, true)
+	if err != nil {
+		slog.Debug("health probe failed", "tag", o.Tag(), "error", err)
+	}
+}
+
+// Healthy 上游是否健康，未知的上游视为不健康
+func (m *Manager) Healthy(tag string) bool {
+	outbound, ok := m.Get(tag)
+	if !ok {
+		return false
+	}
+	if reporter, ok := outbound.(healthReporter); ok {
+		return reporter.Healthy()
+	}
+	return true
+}
+
+// Health 所有上游的健康状态
+func (m *Manager) Health() map[string]HealthStatus {
+	// 上游组的健康状态通过 Healthy 回查成员，复制后释放锁再计算
+	m.access.RLock()
+	outbounds := maps.Clone(m.outbounds)
+	m.access.RUnlock()
+	status := make(map[string]HealthStatus, len(outbounds))
+	for tag, outbound := range outbounds {
+		switch outbound := outbound.(type) {
+		case *checkedOutbound:
+			status[tag] = outbound.Status()
+		case *group.Group:
+			// 上游组的状态由成员决定
+			status[tag] = HealthStatus{Healthy: outbound.Healthy()}
+		}
+	}
+	return status
+}
+
+func (m *Manager) healthLoop() {
+	defer m.wait.Done()
+	ticker := time.NewTicker(m.health.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.closeCh:
+			return
+		case <-ticker.C:
+			m.access.RLock()
+			var wg sync.WaitGroup
+			for _, outbound := range m.outbounds {
+				if checked, ok := outbound.(*checkedOutbound); ok {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						checked.probe()
+					}()
+				}
+			}
+			m.access.RUnlock()
+			wg.Wait()
+		}
+	}
+}
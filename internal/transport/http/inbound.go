@@ -16,7 +16,10 @@ import (
 	"time"
 
 	"github.com/miekg/dns"
-	"github.com/taodev/godns/internal/route"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/taodev/godns/internal/adapter"
+	"github.com/taodev/godns/internal/metrics"
 	"github.com/taodev/godns/internal/utils"
 )
 
@@ -30,18 +33,22 @@ type Options struct {
 	Addr   string `yaml:"addr"`
 	Cert   string `yaml:"cert"`
 	Key    string `yaml:"key"`
+	// 是否同时提供 HTTP/3（仅 https 有效）
+	H3 bool `yaml:"h3"`
 }
 
 type Inbound struct {
 	options    *Options
 	listener   net.Listener
 	httpServer *http.Server
-	router     *route.Router
+	h3Server   *http3.Server
+	h3Conn     net.PacketConn
+	router     adapter.Router
 	wait       sync.WaitGroup
 	running    atomic.Bool
 }
 
-func NewInbound(ctx context.Context, router *route.Router, options *Options) *Inbound {
+func NewInbound(ctx context.Context, router adapter.Router, options *Options) *Inbound {
 	return &Inbound{
 		router:  router,
 		options: options,
@@ -59,9 +66,10 @@ func (h *Inbound) Start() (err error) {
 
 	h.httpServer = &http.Server{
 		Addr:              h.options.Addr,
-		Handler:           mux,
+		Handler:           h.altSvcHandler(mux),
 		ReadHeaderTimeout: defaultTimeout,
 		WriteTimeout:      defaultTimeout,
+		ConnState:         h.trackConn,
 	}
 
 	if h.options.Type == utils.TypeHTTPS {
@@ -74,6 +82,12 @@ func (h *Inbound) Start() (err error) {
 			MinVersion:   tls.VersionTLS12,
 			NextProtos:   []string{"h2", "http/1.1"}, // 非常关键！
 		}
+		if h.options.H3 {
+			if err = h.startH3(mux, cert); err != nil {
+				h.listener.Close()
+				return err
+			}
+		}
 	}
 
 	// 启动 HTTP 服务器
@@ -96,11 +110,61 @@ func (h *Inbound) Start() (err error) {
 	return nil
 }
 
+// startH3 在同一地址的 UDP 端口上提供 HTTP/3 服务
+func (h *Inbound) startH3(handler http.Handler, cert tls.Certificate) (err error) {
+	if h.h3Conn, err = net.ListenPacket("udp", h.options.Addr); err != nil {
+		return err
+	}
+	h.h3Server = &http3.Server{
+		Handler: handler,
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS13,
+		}),
+		QUICConfig: &quic.Config{
+			MaxIdleTimeout: 3 * time.Minute,
+		},
+	}
+	h.wait.Add(1)
+	go func() {
+		defer h.wait.Done()
+		if err := h.h3Server.Serve(h.h3Conn); err != nil && err != http.ErrServerClosed && err != quic.ErrServerClosed {
+			slog.Error("http3 server serve failed", "err", err)
+		}
+	}()
+	slog.Info(fmt.Sprintf("[inbound] %s: %s http3 started", h.options.Type, h.options.Addr))
+	return nil
+}
+
+// altSvcHandler 通过 Alt-Svc 头通告 HTTP/3
+func (h *Inbound) altSvcHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.h3Server != nil && r.ProtoMajor < 3 {
+			h.h3Server.SetQUICHeaders(w.Header())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// trackConn 统计 TCP 连接数
+func (h *Inbound) trackConn(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		metrics.ConnOpened(h.options.Type)
+	case http.StateHijacked, http.StateClosed:
+		metrics.ConnClosed(h.options.Type)
+	}
+}
+
 func (h *Inbound) Close() (err error) {
 	h.running.Store(false)
 	if err = h.httpServer.Shutdown(context.Background()); err != nil {
 		slog.Error("http server shutdown failed", "err", err)
 	}
+	if h.h3Server != nil {
+		h.h3Server.Close()
+		h.h3Conn.Close()
+	}
 	h.wait.Wait()
 	return err
 }
@@ -10,19 +10,97 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 	"github.com/taodev/godns/internal/adapter"
 	"github.com/taodev/godns/internal/utils"
 )
 
+const (
+	// HTTP/3 失败后回退到 h2 的时长
+	h3FallbackDuration = 5 * time.Minute
+
+	defaultIdleTimeout  = 90 * time.Second
+	defaultMaxIdleConns = 4
+	// 连续失败多少次后认为连接池已降级
+	degradedThreshold = 3
+)
+
+// PoolOptions 连接池配置，来自 outbound URL 的查询参数，例如：
+//
+//	https://dns.alidns.com/dns-query?timeout=5s&idleTimeout=90s&maxIdleConns=4&maxConns=16
+type PoolOptions struct {
+	// 单次请求超时（含建连）
+	Timeout time.Duration
+	// 空闲连接保留时长
+	IdleTimeout time.Duration
+	// 最大空闲连接数
+	MaxIdleConns int
+	// 最大连接数，0 表示不限制
+	MaxConns int
+}
+
+// parsePoolOptions 从查询参数中读取连接池配置，并删除已识别的参数
+func parsePoolOptions(query url.Values) (opts PoolOptions, err error) {
+	opts = PoolOptions{
+		Timeout:      defaultTimeout,
+		IdleTimeout:  defaultIdleTimeout,
+		MaxIdleConns: defaultMaxIdleConns,
+	}
+	durations := map[string]*time.Duration{
+		"timeout":     &opts.Timeout,
+		"idleTimeout": &opts.IdleTimeout,
+	}
+	for key, val := range durations {
+		if !query.Has(key) {
+			continue
+		}
+		if *val, err = time.ParseDuration(query.Get(key)); err != nil {
+			return opts, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		query.Del(key)
+	}
+	ints := map[string]*int{
+		"maxIdleConns": &opts.MaxIdleConns,
+		"maxConns":     &opts.MaxConns,
+	}
+	for key, val := range ints {
+		if !query.Has(key) {
+			continue
+		}
+		if *val, err = strconv.Atoi(query.Get(key)); err != nil {
+			return opts, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		query.Del(key)
+	}
+	return opts, nil
+}
+
 type Outbound struct {
 	tag    string
 	typ    string
 	url    string
 	host   string
 	ipAddr string
+
+	// 长连接池，支持 HTTP/2 多路复用
+	pool      PoolOptions
+	client    *http.Client
+	transport *http.Transport
+	// 连续失败次数
+	failures atomic.Int32
+
+	// HTTP/3 支持
+	h3          bool
+	h3Client    *http.Client
+	h3Transport *http3.Transport
+	// 在此时间之前不再尝试 HTTP/3（unix 秒）
+	h3RetryAt atomic.Int64
 }
 
 func NewOutbound(tag, typ, addr string, ip string) adapter.Outbound {
@@ -31,21 +109,81 @@ func NewOutbound(tag, typ, addr string, ip string) adapter.Outbound {
 		slog.Error("parse http outbound url failed", "addr", addr, "error", err)
 		return nil
 	}
+	query := u.Query()
+	// h3://host/dns-query 或 https://host/dns-query?h3=true
+	useH3 := typ == utils.TypeH3
+	if query.Has("h3") {
+		useH3 = query.Get("h3") == "true"
+		query.Del("h3")
+	}
+	pool, err := parsePoolOptions(query)
+	if err != nil {
+		slog.Error("parse http outbound pool options failed", "addr", addr, "error", err)
+		return nil
+	}
+	u.RawQuery = query.Encode()
+	if typ == utils.TypeH3 {
+		u.Scheme = utils.TypeHTTPS
+	}
 	port := u.Port()
 	if len(port) == 0 {
-		if typ == utils.TypeHTTPS {
+		if u.Scheme == utils.TypeHTTPS {
 			port = "443"
 		} else {
 			port = "80"
 		}
 	}
-	return &Outbound{
+	out := &Outbound{
 		tag:    tag,
 		typ:    typ,
-		url:    addr,
+		url:    u.String(),
 		host:   u.Hostname(),
 		ipAddr: net.JoinHostPort(ip, port),
+		pool:   pool,
+		h3:     useH3 && u.Scheme == utils.TypeHTTPS,
+	}
+	dialer := &net.Dialer{Timeout: pool.Timeout, KeepAlive: pool.IdleTimeout}
+	out.transport = &http.Transport{
+		TLSClientConfig: &tls.Config{
+			ServerName:         out.host,
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		},
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			slog.Debug("DialContext", "network", network, "addr", addr, "ipAddr", out.ipAddr)
+			// 固定连接 IP
+			return dialer.DialContext(ctx, network, out.ipAddr)
+		},
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        pool.MaxIdleConns,
+		MaxIdleConnsPerHost: pool.MaxIdleConns,
+		MaxConnsPerHost:     pool.MaxConns,
+		IdleConnTimeout:     pool.IdleTimeout,
+		TLSHandshakeTimeout: pool.Timeout,
+	}
+	out.client = &http.Client{
+		Timeout:   pool.Timeout,
+		Transport: out.transport,
+	}
+	if out.h3 {
+		out.h3Transport = &http3.Transport{
+			TLSClientConfig: &tls.Config{
+				ServerName:         out.host,
+				ClientSessionCache: tls.NewLRUClientSessionCache(0),
+			},
+			QUICConfig: &quic.Config{
+				MaxIdleTimeout: pool.IdleTimeout,
+			},
+			Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+				// 固定连接 IP
+				return quic.DialAddrEarly(ctx, out.ipAddr, tlsCfg, cfg)
+			},
+		}
+		out.h3Client = &http.Client{
+			Timeout:   pool.Timeout,
+			Transport: out.h3Transport,
+		}
 	}
+	return out
 }
 
 func (h *Outbound) Tag() string {
@@ -56,6 +194,11 @@ func (h *Outbound) Type() string {
 	return h.typ
 }
 
+// Healthy 连接池是否健康，连续失败达到阈值后视为降级
+func (h *Outbound) Healthy() bool {
+	return h.failures.Load() < degradedThreshold
+}
+
 func (h *Outbound) Exchange(req *dns.Msg) (resp *dns.Msg, rtt time.Duration, err error) {
 	// In order to maximize HTTP cache friendliness, DoH clients using media
 	// formats that include the ID field from the DNS message header, such as
@@ -77,50 +220,73 @@ func (h *Outbound) Exchange(req *dns.Msg) (resp *dns.Msg, rtt time.Duration, err
 		return nil, 0, err
 	}
 
+	now := time.Now()
+	if h.h3 && now.Unix() >= h.h3RetryAt.Load() {
+		if resp, err = h.roundTrip(h.h3Client, buf); err == nil {
+			return resp, time.Since(now), nil
+		}
+		// HTTP/3 不可用时回退到 h2
+		slog.Warn("http3 exchange failed, fallback to h2", "tag", h.tag, "error", err)
+		h.h3RetryAt.Store(time.Now().Add(h3FallbackDuration).Unix())
+	}
+
+	if resp, err = h.roundTrip(h.client, buf); err != nil {
+		h.markFailure(err)
+		return nil, time.Since(now), err
+	}
+	h.markSuccess()
+	return resp, time.Since(now), nil
+}
+
+func (h *Outbound) markFailure(err error) {
+	if h.failures.Add(1) == degradedThreshold {
+		// 丢弃可能已失效的空闲连接，下次请求重新建连
+		slog.Warn("http outbound pool degraded", "tag", h.tag, "error", err)
+		h.transport.CloseIdleConnections()
+	}
+}
+
+func (h *Outbound) markSuccess() {
+	if h.failures.Swap(0) >= degradedThreshold {
+		slog.Info("http outbound pool recovered", "tag", h.tag)
+	}
+}
+
+func (h *Outbound) roundTrip(client *http.Client, buf []byte) (resp *dns.Msg, err error) {
 	httpReq, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(buf))
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	httpReq.Header.Set("User-Agent", "")
 	httpReq.Header.Set("Content-Type", "application/dns-message")
 	httpReq.Header.Set("Accept", "application/dns-message")
-	client := &http.Client{
-		Timeout: defaultTimeout,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				ServerName: h.host,
-			},
-			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-				slog.Info("DialContext", "network", network, "addr", addr, "ipAddr", h.ipAddr)
-				// 固定连接 IP
-				dialer := &net.Dialer{Timeout: defaultTimeout}
-				return dialer.DialContext(ctx, network, h.ipAddr)
-			},
-		},
-	}
 	httpResp, err := client.Do(httpReq)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	defer httpResp.Body.Close()
 	respBody, err := io.ReadAll(httpResp.Body)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	if httpResp.StatusCode != http.StatusOK {
-		return nil, 0, fmt.Errorf("unexpected status code: %d", httpResp.StatusCode)
+		return nil, fmt.Errorf("unexpected status code: %d", httpResp.StatusCode)
 	}
 
 	resp = new(dns.Msg)
 	err = resp.Unpack(respBody)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
-	if resp.Id != req.Id {
-		return nil, 0, fmt.Errorf("unexpected id: %d", resp.Id)
+	if resp.Id != 0 {
+		return nil, fmt.Errorf("unexpected id: %d", resp.Id)
 	}
-	return resp, 0, nil
+	return resp, nil
 }
 
 func (h *Outbound) Close() {
+	h.transport.CloseIdleConnections()
+	if h.h3Transport != nil {
+		h.h3Transport.Close()
+	}
 }
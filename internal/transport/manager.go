@@ -2,9 +2,9 @@ package transport
 
 import (
 	"fmt"
-	"log/slog"
 	"net"
 	"net/url"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -14,7 +14,10 @@ import (
 
 	"github.com/miekg/dns"
 	"github.com/taodev/godns/internal/adapter"
+	"github.com/taodev/godns/internal/transport/group"
 	"github.com/taodev/godns/internal/transport/http"
+	"github.com/taodev/godns/internal/transport/quic"
+	"github.com/taodev/godns/internal/transport/recursive"
 	"github.com/taodev/godns/internal/transport/tcp"
 	"github.com/taodev/godns/internal/transport/udp"
 	"github.com/taodev/godns/internal/utils"
@@ -27,20 +30,64 @@ type Manager struct {
 
 	outbounds map[string]adapter.Outbound
 	stcpKey   string
+	health    *HealthOptions
+
+	closeCh chan struct{}
+	wait    sync.WaitGroup
 }
 
-func NewManager(opts map[string]string, stcpKey string) *Manager {
+func NewManager(opts map[string]string, groups map[string]*group.Options, health *HealthOptions, stcpKey string) (*Manager, error) {
+	if _, ok := dns.StringToType[health.ProbeType]; !ok {
+		return nil, fmt.Errorf("health: unsupported probe type %s", health.ProbeType)
+	}
 	m := &Manager{
 		outbounds: make(map[string]adapter.Outbound),
 		stcpKey:   stcpKey,
+		health:    health,
+		closeCh:   make(chan struct{}),
 	}
 	for name, addr := range opts {
-		m.Add(name, addr)
+		if err := m.Add(name, addr); err != nil {
+			m.Close()
+			return nil, err
+		}
+	}
+	for name, opt := range groups {
+		if err := m.AddGroup(name, opt); err != nil {
+			m.Close()
+			return nil, err
+		}
 	}
-	return m
+	if err := group.Check(groups, func(tag string) bool {
+		_, ok := m.Get(tag)
+		return ok
+	}); err != nil {
+		m.Close()
+		return nil, err
+	}
+	if health.Interval > 0 {
+		m.wait.Add(1)
+		go m.healthLoop()
+	}
+	return m, nil
 }
 
-func (m *Manager) Add(tag string, addr string) {
+// 添加上游组，成员在请求时按标签查找，因此组可以引用其他组
+func (m *Manager) AddGroup(tag string, opts *group.Options) error {
+	g, err := group.New(tag, opts, m)
+	if err != nil {
+		return fmt.Errorf("outbound group %s: %w", tag, err)
+	}
+	m.access.Lock()
+	defer m.access.Unlock()
+	if _, ok := m.outbounds[tag]; ok {
+		return fmt.Errorf("duplicate outbound tag %s", tag)
+	}
+	m.outbounds[tag] = g
+	return nil
+}
+
+func (m *Manager) Add(tag string, addr string) error {
 	m.access.Lock()
 	defer m.access.Unlock()
 
@@ -49,13 +96,21 @@ func (m *Manager) Add(tag string, addr string) {
 	}
 	u, err := url.Parse(addr)
 	if err != nil {
-		slog.Error("invalid outbound", "addr", addr, "error", err)
-		return
+		return fmt.Errorf("outbound %s: %w", tag, err)
 	}
 	scheme := u.Scheme
 	if len(scheme) == 0 {
 		scheme = utils.TypeUDP
 	}
+	if scheme == utils.TypeRecursive {
+		// 递归解析直接查询权威服务器，没有上游地址
+		outbound, err := recursive.NewOutbound(tag, u.Query())
+		if err != nil {
+			return fmt.Errorf("outbound %s: %w", tag, err)
+		}
+		m.outbounds[tag] = newCheckedOutbound(outbound, m.health)
+		return nil
+	}
 	host := u.Hostname()
 	port := u.Port()
 	ip := host
@@ -63,39 +118,47 @@ func (m *Manager) Add(tag string, addr string) {
 		// 处理域名
 		ip, err = bootstrap.Cache(host)
 		if err != nil {
-			slog.Error("dns bootstrap failed", "error", err)
-			return
+			return fmt.Errorf("outbound %s: dns bootstrap: %w", tag, err)
 		}
 	}
 
+	var outbound adapter.Outbound
 	switch u.Scheme {
 	case utils.TypeTCP:
 		if len(port) == 0 {
 			port = "53"
 		}
-		m.outbounds[tag] = tcp.NewOutbound(tag, u.Scheme, net.JoinHostPort(ip, port), host, "", "")
+		outbound, err = tcp.NewOutbound(tag, u.Scheme, net.JoinHostPort(ip, port), host, u.Query())
 	case utils.TypeTLS:
 		if len(port) == 0 {
 			port = "853"
 		}
-		m.outbounds[tag] = tcp.NewOutbound(tag, u.Scheme, net.JoinHostPort(ip, port), host, "", "")
+		outbound, err = tcp.NewOutbound(tag, u.Scheme, net.JoinHostPort(ip, port), host, u.Query())
 	case utils.TypeSTCP:
-		m.outbounds[tag], err = tcp.NewOutboundSTCP(tag, addr, m.stcpKey)
-		if err != nil {
-			slog.Error("invalid outbound", "tag", tag, "error", err)
-			return
+		outbound, err = tcp.NewOutboundSTCP(tag, addr, m.stcpKey)
+	case utils.TypeQUIC:
+		if len(port) == 0 {
+			port = "853"
+		}
+		outbound = quic.NewOutbound(tag, u.Scheme, net.JoinHostPort(ip, port), host)
+	case utils.TypeHTTP, utils.TypeHTTPS, utils.TypeH3:
+		if outbound = http.NewOutbound(tag, u.Scheme, addr, ip); outbound == nil {
+			return fmt.Errorf("outbound %s: invalid address %s", tag, addr)
 		}
-	case utils.TypeHTTP, utils.TypeHTTPS:
-		m.outbounds[tag] = http.NewOutbound(tag, u.Scheme, addr, ip)
 	case utils.TypeUDP:
 		if len(port) == 0 {
 			port = "53"
 		}
-		m.outbounds[tag] = udp.NewOutbound(tag, u.Scheme, net.JoinHostPort(ip, port))
+		outbound = udp.NewOutbound(tag, u.Scheme, net.JoinHostPort(ip, port))
 	default:
 		// 暂时不支持的协议
-		slog.Error("unsupported upstream protocol", "protocol", u.Scheme, "addr", addr)
+		return fmt.Errorf("outbound %s: unsupported protocol %s", tag, u.Scheme)
 	}
+	if err != nil {
+		return fmt.Errorf("outbound %s: %w", tag, err)
+	}
+	m.outbounds[tag] = newCheckedOutbound(outbound, m.health)
+	return nil
 }
 
 // 获取 Outbound
@@ -106,6 +169,44 @@ func (m *Manager) Get(tag string) (adapter.Outbound, bool) {
 	return outbound, ok
 }
 
+// OutboundInfo 上游信息
+type OutboundInfo struct {
+	Tag     string `json:"tag"`
+	Type    string `json:"type"`
+	Healthy bool   `json:"healthy"`
+}
+
+// 列出所有 Outbound
+func (m *Manager) List() []OutboundInfo {
+	m.access.RLock()
+	tags := make([]string, 0, len(m.outbounds))
+	for tag := range m.outbounds {
+		tags = append(tags, tag)
+	}
+	m.access.RUnlock()
+	slices.Sort(tags)
+
+	list := make([]OutboundInfo, 0, len(tags))
+	for _, tag := range tags {
+		outbound, ok := m.Get(tag)
+		if !ok {
+			continue
+		}
+		if checked, ok := outbound.(*checkedOutbound); ok {
+			outbound = checked.Outbound
+		}
+		info := OutboundInfo{Tag: tag, Healthy: m.Healthy(tag)}
+		switch outbound := outbound.(type) {
+		case *group.Group:
+			info.Type = "group"
+		case interface{ Type() string }:
+			info.Type = outbound.Type()
+		}
+		list = append(list, info)
+	}
+	return list
+}
+
 // 移除 Outbound
 func (m *Manager) Remove(tag string) {
 	m.access.Lock()
@@ -130,6 +231,8 @@ func (m *Manager) Exchange(tag string, in *dns.Msg) (*dns.Msg, time.Duration, er
 }
 
 func (m *Manager) Close() {
+	close(m.closeCh)
+	m.wait.Wait()
 	for _, outbound := range m.outbounds {
 		outbound.Close()
 	}
@@ -0,0 +1,157 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"github.com/taodev/godns/internal/adapter"
+	"github.com/taodev/godns/internal/utils"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	// 连接空闲超时
+	defaultIdleTimeout = 3 * time.Minute
+)
+
+type Options struct {
+	Type string `yaml:"-"`
+	Addr string `yaml:"addr"`
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+}
+
+type Inbound struct {
+	options  *Options
+	listener *quic.Listener
+	router   adapter.Router
+	wait     sync.WaitGroup
+	running  atomic.Bool
+	// 已接受的连接，关闭时逐个关闭
+	access sync.Mutex
+	conns  map[*quic.Conn]struct{}
+}
+
+func NewInbound(ctx context.Context, router adapter.Router, options *Options) *Inbound {
+	return &Inbound{
+		router:  router,
+		options: options,
+		conns:   make(map[*quic.Conn]struct{}),
+	}
+}
+
+func (h *Inbound) Start() (err error) {
+	var cert tls.Certificate
+	if cert, err = tls.LoadX509KeyPair(h.options.Cert, h.options.Key); err != nil {
+		return err
+	}
+	h.listener, err = quic.ListenAddr(h.options.Addr, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS13,
+		NextProtos:   []string{NextProtoDQ},
+	}, &quic.Config{
+		MaxIdleTimeout: defaultIdleTimeout,
+	})
+	if err != nil {
+		return err
+	}
+	h.running.Store(true)
+	h.wait.Add(1)
+	go h.handleAccept()
+	slog.Info(fmt.Sprintf("[inbound] %s: %s started", h.options.Type, h.options.Addr))
+	return nil
+}
+
+// Close 关闭监听与已接受的连接（DOQ_NO_ERROR），等待处理中的查询结束
+func (h *Inbound) Close() error {
+	h.running.Store(false)
+	err := h.listener.Close()
+	h.access.Lock()
+	for conn := range h.conns {
+		conn.CloseWithError(doqNoError, "")
+	}
+	h.access.Unlock()
+	h.wait.Wait()
+	return err
+}
+
+func (h *Inbound) handleAccept() {
+	defer h.wait.Done()
+	for {
+		conn, err := h.listener.Accept(context.Background())
+		if err != nil {
+			if errors.Is(err, quic.ErrServerClosed) {
+				slog.Debug("quic listener closed, exiting accept loop")
+				return
+			}
+			slog.Error("accept error", "err", err)
+			return
+		}
+		h.access.Lock()
+		// Close 已开始，不再接受新连接
+		if !h.running.Load() {
+			h.access.Unlock()
+			conn.CloseWithError(doqNoError, "")
+			continue
+		}
+		h.conns[conn] = struct{}{}
+		h.wait.Add(1)
+		h.access.Unlock()
+		go h.handleConn(conn)
+	}
+}
+
+func (h *Inbound) handleConn(conn *quic.Conn) {
+	defer h.wait.Done()
+	defer func() {
+		h.access.Lock()
+		delete(h.conns, conn)
+		h.access.Unlock()
+	}()
+	raddr, _ := netip.ParseAddrPort(conn.RemoteAddr().String())
+	for h.running.Load() {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		h.wait.Add(1)
+		go h.handleStream(conn, stream, raddr.Addr().String())
+	}
+	conn.CloseWithError(doqNoError, "")
+}
+
+func (h *Inbound) handleStream(conn *quic.Conn, stream *quic.Stream, ip string) {
+	defer h.wait.Done()
+	defer stream.Close()
+	if err := stream.SetDeadline(time.Now().Add(defaultTimeout)); err != nil {
+		return
+	}
+	req, err := read(stream)
+	if err != nil {
+		slog.Debug("quic read failed", "addr", conn.RemoteAddr(), "err", err)
+		conn.CloseWithError(doqProtocolError, err.Error())
+		return
+	}
+	// DoQ 要求消息 ID 必须为 0，见 RFC 9250 Section 4.2.1
+	if req.Id != 0 {
+		conn.CloseWithError(doqProtocolError, "message id must be 0")
+		return
+	}
+	var resp *dns.Msg
+	if resp, err = h.router.Exchange(req, h.options.Type, ip); err != nil {
+		resp = utils.NewMsgSERVFAIL(req)
+	}
+	if err = write(stream, resp); err != nil {
+		slog.Debug("quic write failed", "addr", conn.RemoteAddr(), "err", err)
+		stream.CancelWrite(quic.StreamErrorCode(doqInternalError))
+	}
+}
@@ -0,0 +1,159 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"github.com/taodev/godns/internal/adapter"
+)
+
+type Outbound struct {
+	tag      string
+	typ      string
+	addr     string
+	hostname string
+
+	tlsConfig  *tls.Config
+	quicConfig *quic.Config
+
+	// 复用的 QUIC 连接，每个查询使用独立的 stream
+	access sync.Mutex
+	conn   *quic.Conn
+	closed bool
+}
+
+func NewOutbound(tag, typ, addr string, hostname string) adapter.Outbound {
+	return &Outbound{
+		tag:      tag,
+		typ:      typ,
+		addr:     addr,
+		hostname: hostname,
+		tlsConfig: &tls.Config{
+			ServerName:         hostname,
+			NextProtos:         []string{NextProtoDQ},
+			MinVersion:         tls.VersionTLS13,
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		},
+		quicConfig: &quic.Config{
+			MaxIdleTimeout:  defaultIdleTimeout,
+			KeepAlivePeriod: defaultIdleTimeout / 2,
+		},
+	}
+}
+
+func (h *Outbound) Tag() string {
+	return h.tag
+}
+
+func (h *Outbound) Type() string {
+	return h.typ
+}
+
+func (h *Outbound) Exchange(in *dns.Msg) (resp *dns.Msg, rtt time.Duration, err error) {
+	now := time.Now()
+	conn, err := h.getConn()
+	if err != nil {
+		return nil, time.Since(now), err
+	}
+	resp, err = h.exchange(conn, in)
+	if err != nil && isConnError(err) {
+		// 连接已失效，重新建立连接后重试一次
+		h.resetConn(conn)
+		if conn, err = h.getConn(); err != nil {
+			return nil, time.Since(now), err
+		}
+		resp, err = h.exchange(conn, in)
+	}
+	if err != nil {
+		return nil, time.Since(now), err
+	}
+	return resp, time.Since(now), nil
+}
+
+func (h *Outbound) exchange(conn *quic.Conn, in *dns.Msg) (resp *dns.Msg, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = stream.SetDeadline(time.Now().Add(defaultTimeout)); err != nil {
+		return nil, err
+	}
+
+	// DoQ 要求消息 ID 必须为 0，见 RFC 9250 Section 4.2.1
+	id := in.Id
+	in.Id = 0
+	err = write(stream, in)
+	in.Id = id
+	if err != nil {
+		stream.CancelRead(quic.StreamErrorCode(doqNoError))
+		return nil, err
+	}
+	// 发送 FIN，表示请求已写完
+	stream.Close()
+	if resp, err = read(stream); err != nil {
+		stream.CancelRead(quic.StreamErrorCode(doqNoError))
+		return nil, err
+	}
+	// 修复响应 ID
+	resp.Id = id
+	return resp, nil
+}
+
+func (h *Outbound) getConn() (*quic.Conn, error) {
+	h.access.Lock()
+	defer h.access.Unlock()
+	if h.closed {
+		return nil, errors.New("closed")
+	}
+	if h.conn != nil && h.conn.Context().Err() == nil {
+		return h.conn, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, h.addr, h.tlsConfig, h.quicConfig)
+	if err != nil {
+		return nil, err
+	}
+	h.conn = conn
+	return conn, nil
+}
+
+func (h *Outbound) resetConn(conn *quic.Conn) {
+	h.access.Lock()
+	defer h.access.Unlock()
+	if h.conn == conn {
+		h.conn = nil
+	}
+	conn.CloseWithError(doqNoError, "")
+}
+
+func (h *Outbound) Close() {
+	h.access.Lock()
+	defer h.access.Unlock()
+	h.closed = true
+	if h.conn != nil {
+		h.conn.CloseWithError(doqNoError, "")
+		h.conn = nil
+	}
+}
+
+// isConnError 判断错误是否由连接失效引起
+func isConnError(err error) bool {
+	var (
+		idleErr  *quic.IdleTimeoutError
+		resetErr *quic.StatelessResetError
+		appErr   *quic.ApplicationError
+		tpErr    *quic.TransportError
+	)
+	return errors.As(err, &idleErr) ||
+		errors.As(err, &resetErr) ||
+		errors.As(err, &appErr) ||
+		errors.As(err, &tpErr)
+}
@@ -0,0 +1,55 @@
+package quic
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/bytedance/gopkg/lang/mcache"
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// NextProtoDQ DoQ 的 ALPN 标识，见 RFC 9250 Section 4.1
+const NextProtoDQ = "doq"
+
+// DoQ 错误码，见 RFC 9250 Section 4.3
+const (
+	doqNoError       quic.ApplicationErrorCode = 0x0
+	doqInternalError quic.ApplicationErrorCode = 0x1
+	doqProtocolError quic.ApplicationErrorCode = 0x2
+)
+
+func read(r io.Reader) (req *dns.Msg, err error) {
+	var length uint16
+	buf := mcache.Malloc(2 + dns.MaxMsgSize)
+	defer mcache.Free(buf)
+	if _, err = io.ReadFull(r, buf[:2]); err != nil {
+		return nil, err
+	}
+	if length = binary.BigEndian.Uint16(buf[:2]); length > dns.MaxMsgSize || length == 0 {
+		return nil, fmt.Errorf("invalid length: %d", length)
+	}
+	if _, err = io.ReadFull(r, buf[:length]); err != nil {
+		return nil, err
+	}
+
+	req = new(dns.Msg)
+	if err = req.Unpack(buf[:length]); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func write(w io.Writer, m *dns.Msg) (err error) {
+	bytes, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	buf := mcache.Malloc(2 + dns.MaxMsgSize)
+	defer mcache.Free(buf)
+	binary.BigEndian.PutUint16(buf, uint16(len(bytes)))
+	n := copy(buf[2:], bytes)
+	_, err = w.Write(buf[:2+n])
+	return err
+}
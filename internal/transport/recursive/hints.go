@@ -0,0 +1,69 @@
+package recursive
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// 内置根提示（https://www.internic.net/domain/named.root）
+var rootHints = []struct {
+	name string
+	ipv4 string
+	ipv6 string
+}{
+	{"a.root-servers.net.", "198.41.0.4", "2001:503:ba3e::2:30"},
+	{"b.root-servers.net.", "170.247.170.2", "2801:1b8:10::b"},
+	{"c.root-servers.net.", "192.33.4.12", "2001:500:2::c"},
+	{"d.root-servers.net.", "199.7.91.13", "2001:500:2d::d"},
+	{"e.root-servers.net.", "192.203.230.10", "2001:500:a8::e"},
+	{"f.root-servers.net.", "192.5.5.241", "2001:500:2f::f"},
+	{"g.root-servers.net.", "192.112.36.4", "2001:500:12::d0d"},
+	{"h.root-servers.net.", "198.97.190.53", "2001:500:1::53"},
+	{"i.root-servers.net.", "192.36.148.17", "2001:7fe::53"},
+	{"j.root-servers.net.", "192.58.128.30", "2001:503:c27::2:30"},
+	{"k.root-servers.net.", "193.0.14.129", "2001:7fd::1"},
+	{"l.root-servers.net.", "199.7.83.42", "2001:500:9f::42"},
+	{"m.root-servers.net.", "202.12.27.33", "2001:dc3::35"},
+}
+
+// loadHints 读取根提示，path 为空时使用内置根提示
+func loadHints(path string) (*delegation, error) {
+	root := &delegation{zone: "."}
+	if path == "" {
+		for _, h := range rootHints {
+			root.ns = append(root.ns, h.name)
+			root.addrs = append(root.addrs, netip.MustParseAddr(h.ipv4), netip.MustParseAddr(h.ipv6))
+		}
+		return root, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zp := dns.NewZoneParser(f, ".", path)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		switch rr := rr.(type) {
+		case *dns.NS:
+			if rr.Hdr.Name == "." {
+				root.ns = append(root.ns, strings.ToLower(rr.Ns))
+			}
+		case *dns.A:
+			root.addrs = appendAddr(root.addrs, rr.A)
+		case *dns.AAAA:
+			root.addrs = appendAddr(root.addrs, rr.AAAA)
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	if len(root.addrs) == 0 {
+		return nil, fmt.Errorf("no root server address in %s", path)
+	}
+	return root, nil
+}
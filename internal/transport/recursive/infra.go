@@ -0,0 +1,108 @@
+package recursive
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// 委派与地址的最长缓存时间
+	maxInfraTTL = 24 * time.Hour
+	// 最短缓存时间，避免 TTL 为 0 的委派每次都重新查询
+	minInfraTTL = 5 * time.Second
+)
+
+// delegation 区域分割点的权威服务器
+type delegation struct {
+	zone string
+	// NS 主机名
+	ns []string
+	// 胶水记录或已解析的服务器地址
+	addrs  []netip.Addr
+	expire time.Time
+}
+
+type host struct {
+	addrs  []netip.Addr
+	expire time.Time
+}
+
+// infra 基础设施缓存：委派（NS/胶水）与权威服务器地址
+type infra struct {
+	size int
+
+	access sync.Mutex
+	zones  map[string]*delegation
+	hosts  map[string]*host
+}
+
+func newInfra(size int) *infra {
+	return &infra{
+		size:  max(size, 1),
+		zones: make(map[string]*delegation),
+		hosts: make(map[string]*host),
+	}
+}
+
+func infraTTL(ttl uint32) time.Duration {
+	return min(max(time.Duration(ttl)*time.Second, minInfraTTL), maxInfraTTL)
+}
+
+// delegation 查找 zone 的委派，过期时返回 nil
+func (c *infra) delegation(zone string) *delegation {
+	c.access.Lock()
+	defer c.access.Unlock()
+	d, ok := c.zones[zone]
+	if !ok || time.Now().After(d.expire) {
+		return nil
+	}
+	return d
+}
+
+func (c *infra) setDelegation(d *delegation) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	evict(c.zones, c.size, func(d *delegation) time.Time { return d.expire })
+	c.zones[d.zone] = d
+}
+
+// host 查找权威服务器主机名的地址，解析失败的结果同样缓存
+func (c *infra) host(name string) ([]netip.Addr, bool) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	h, ok := c.hosts[name]
+	if !ok || time.Now().After(h.expire) {
+		return nil, false
+	}
+	return h.addrs, true
+}
+
+func (c *infra) setHost(name string, addrs []netip.Addr, ttl time.Duration) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	evict(c.hosts, c.size, func(h *host) time.Time { return h.expire })
+	c.hosts[name] = &host{addrs: addrs, expire: time.Now().Add(ttl)}
+}
+
+// evict 缓存已满时移除过期条目，仍然满时随机移除
+func evict[V any](m map[string]V, size int, expire func(V) time.Time) {
+	if len(m) < size {
+		return
+	}
+	now := time.Now()
+	for k, v := range m {
+		if now.After(expire(v)) || len(m) >= size {
+			delete(m, k)
+		}
+	}
+}
+
+func appendAddr(addrs []netip.Addr, ip net.IP) []netip.Addr {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return addrs
+	}
+	return append(addrs, addr.Unmap())
+}
@@ -0,0 +1,142 @@
+package recursive
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/taodev/godns/internal/adapter"
+	"github.com/taodev/godns/internal/utils"
+)
+
+// Options 递归解析配置，来自 outbound URL 的查询参数，例如：
+//
+//	recursive://?qnameMin=true&maxDepth=5&maxQueries=64&timeout=2s&ipv6=false&hints=conf/named.root&port=53
+type Options struct {
+	// 根提示文件（named.root 格式），为空时使用内置根提示
+	Hints string
+	// 权威服务器端口
+	Port uint16
+	// QNAME 最小化（RFC 9156）
+	QnameMin bool
+	// 是否使用 IPv6 地址的权威服务器
+	IPv6 bool
+	// 解析权威服务器地址的最大嵌套深度
+	MaxDepth int
+	// 单次查询最多向权威服务器发送的请求数
+	MaxQueries int
+	// 单个权威服务器请求超时
+	Timeout time.Duration
+	// 基础设施缓存条目数
+	CacheSize int
+}
+
+func parseOptions(query url.Values) (opts Options, err error) {
+	opts = Options{
+		Hints:      query.Get("hints"),
+		Port:       defaultPort,
+		QnameMin:   query.Get("qnameMin") != "false",
+		IPv6:       query.Get("ipv6") == "true",
+		MaxDepth:   defaultMaxDepth,
+		MaxQueries: defaultMaxQueries,
+		Timeout:    defaultTimeout,
+		CacheSize:  defaultCacheSize,
+	}
+	ints := []struct {
+		name string
+		v    *int
+	}{
+		{"maxDepth", &opts.MaxDepth},
+		{"maxQueries", &opts.MaxQueries},
+		{"cacheSize", &opts.CacheSize},
+	}
+	for _, p := range ints {
+		if v := query.Get(p.name); v != "" {
+			if *p.v, err = strconv.Atoi(v); err != nil || *p.v <= 0 {
+				return opts, fmt.Errorf("invalid %s: %s", p.name, v)
+			}
+		}
+	}
+	if v := query.Get("port"); v != "" {
+		port, err := strconv.ParseUint(v, 10, 16)
+		if err != nil || port == 0 {
+			return opts, fmt.Errorf("invalid port: %s", v)
+		}
+		opts.Port = uint16(port)
+	}
+	if v := query.Get("timeout"); v != "" {
+		if opts.Timeout, err = time.ParseDuration(v); err != nil {
+			return opts, fmt.Errorf("invalid timeout: %w", err)
+		}
+	}
+	return opts, nil
+}
+
+// Outbound 内置递归解析器，从根服务器开始迭代查询，不依赖其他解析器
+type Outbound struct {
+	tag  string
+	opts Options
+
+	root  *delegation
+	infra *infra
+}
+
+func NewOutbound(tag string, query url.Values) (adapter.Outbound, error) {
+	opts, err := parseOptions(query)
+	if err != nil {
+		return nil, err
+	}
+	root, err := loadHints(opts.Hints)
+	if err != nil {
+		return nil, fmt.Errorf("load root hints: %w", err)
+	}
+	return &Outbound{
+		tag:   tag,
+		opts:  opts,
+		root:  root,
+		infra: newInfra(opts.CacheSize),
+	}, nil
+}
+
+func (o *Outbound) Tag() string {
+	return o.tag
+}
+
+func (o *Outbound) Type() string {
+	return utils.TypeRecursive
+}
+
+func (o *Outbound) Exchange(req *dns.Msg) (resp *dns.Msg, rtt time.Duration, err error) {
+	now := time.Now()
+	if len(req.Question) == 0 {
+		return nil, 0, errNoQuestion
+	}
+	q := req.Question[0]
+	r := &resolver{
+		o:        o,
+		qclass:   q.Qclass,
+		deadline: now.Add(maxDuration),
+	}
+	if opt := req.IsEdns0(); opt != nil {
+		r.do = opt.Do()
+	}
+	result, err := r.resolve(dns.CanonicalName(q.Name), q.Qtype, 0)
+	if err != nil {
+		return nil, time.Since(now), fmt.Errorf("resolve %s %s: %w", q.Name, dns.TypeToString[q.Qtype], err)
+	}
+
+	resp = new(dns.Msg)
+	resp.SetRcode(req, result.Rcode)
+	resp.RecursionAvailable = true
+	resp.Answer = result.Answer
+	resp.Ns = result.Ns
+	if opt := req.IsEdns0(); opt != nil {
+		resp.SetEdns0(opt.UDPSize(), r.do)
+	}
+	return resp, time.Since(now), nil
+}
+
+func (o *Outbound) Close() {
+}
@@ -0,0 +1,416 @@
+package recursive
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultMaxDepth   = 5
+	defaultMaxQueries = 64
+	defaultTimeout    = 2 * time.Second
+	defaultCacheSize  = 10000
+	defaultPort       = 53
+	// 单次查询的最长耗时
+	maxDuration = 10 * time.Second
+	// 最长 CNAME 链
+	maxChain = 8
+	// QNAME 最小化最多逐级查询的次数（RFC 9156 MAX_MINIMISE_COUNT）
+	maxMinimise = 10
+	// 向权威服务器通告的 EDNS 缓冲区大小
+	ednsSize = 1232
+)
+
+var (
+	errNoQuestion = errors.New("no question")
+	errMaxQueries = errors.New("too many queries")
+	errMaxDepth   = errors.New("dependency too deep")
+	errTimeout    = errors.New("resolution timed out")
+	errCNAMELoop  = errors.New("CNAME chain too long")
+	errNoServer   = errors.New("no reachable authoritative server")
+	errMismatch   = errors.New("question mismatch")
+)
+
+// resolver 单次查询的迭代解析状态
+type resolver struct {
+	o      *Outbound
+	qclass uint16
+	// 客户端请求了 DNSSEC 记录
+	do       bool
+	deadline time.Time
+	// 已发送的请求数
+	queries int
+}
+
+// resolve 迭代解析 name，应答为 CNAME 且目标不在同一区域时继续解析目标，depth 为权威服务器地址解析的嵌套深度
+func (r *resolver) resolve(name string, qtype uint16, depth int) (*dns.Msg, error) {
+	if depth > r.o.opts.MaxDepth {
+		return nil, errMaxDepth
+	}
+	var answer []dns.RR
+	seen := make(map[string]bool)
+	for range maxChain {
+		seen[name] = true
+		resp, err := r.iterate(name, qtype, depth)
+		if err != nil {
+			return nil, err
+		}
+		answer = append(answer, resp.Answer...)
+		resp.Answer = answer
+		target := chase(resp, name, qtype)
+		if target == "" {
+			return resp, nil
+		}
+		if seen[target] {
+			return nil, errCNAMELoop
+		}
+		name = target
+	}
+	return nil, errCNAMELoop
+}
+
+// chase 应答以 CNAME 结束且权威服务器未给出目标的记录时，返回需要继续解析的目标。
+// resp 只含区域内的记录，区域外的目标由此从最近的已知委派重新解析
+func chase(resp *dns.Msg, name string, qtype uint16) string {
+	if resp.Rcode != dns.RcodeSuccess || qtype == dns.TypeCNAME || qtype == dns.TypeANY {
+		return ""
+	}
+	target := ""
+	for range maxChain {
+		for _, rr := range resp.Answer {
+			if rr.Header().Rrtype == qtype && strings.EqualFold(rr.Header().Name, name) {
+				return ""
+			}
+		}
+		next := alias(resp.Answer, name)
+		if next == "" {
+			break
+		}
+		name, target = next, next
+	}
+	if target == "" {
+		return ""
+	}
+	// 目标在应答的区域内，权威服务器已给出否定应答
+	for _, rr := range resp.Ns {
+		if soa, ok := rr.(*dns.SOA); ok && dns.IsSubDomain(soa.Hdr.Name, target) {
+			return ""
+		}
+	}
+	return target
+}
+
+// alias 返回 name 的别名目标：CNAME，或权威服务器未合成 CNAME 时由 DNAME 推出（RFC 6672），没有时返回空
+func alias(answer []dns.RR, name string) string {
+	var dname string
+	for _, rr := range answer {
+		switch rr := rr.(type) {
+		case *dns.CNAME:
+			if strings.EqualFold(rr.Hdr.Name, name) {
+				return dns.CanonicalName(rr.Target)
+			}
+		case *dns.DNAME:
+			owner := dns.CanonicalName(rr.Hdr.Name)
+			if owner == name || !dns.IsSubDomain(owner, name) {
+				continue
+			}
+			target := strings.TrimSuffix(name, owner)
+			if t := dns.CanonicalName(rr.Target); t != "." {
+				target += t
+			}
+			if _, ok := dns.IsDomainName(target); ok {
+				dname = target
+			}
+		}
+	}
+	return dname
+}
+
+// inBailiwick 只保留 zone 及其下级的记录，服务器无权提供区域外的记录
+func inBailiwick(rrs []dns.RR, zone string) []dns.RR {
+	return slices.DeleteFunc(rrs, func(rr dns.RR) bool {
+		return !dns.IsSubDomain(zone, rr.Header().Name)
+	})
+}
+
+// iterate 从最近的已知委派开始逐级查询 name，不跟随 CNAME
+func (r *resolver) iterate(name string, qtype uint16, depth int) (*dns.Msg, error) {
+	// DS 记录由父区域提供
+	start := name
+	if qtype == dns.TypeDS && name != "." {
+		start = parent(name)
+	}
+	d := r.closest(start)
+	known := dns.CountLabel(d.zone)
+	minimise := r.o.opts.QnameMin
+	for steps := 0; ; steps++ {
+		if steps >= maxMinimise {
+			minimise = false
+		}
+		// QNAME 最小化：每次只向权威服务器多暴露一个标签（RFC 9156）
+		qname, qt := name, qtype
+		if minimise && known+1 < dns.CountLabel(name) {
+			qname, qt = ancestor(name, known+1), dns.TypeA
+		}
+		resp, err := r.query(d, qname, qt, depth)
+		if err != nil {
+			if qname == name || errors.Is(err, errMaxQueries) || errors.Is(err, errTimeout) {
+				return nil, err
+			}
+			// 部分服务器不能正确应答最小化查询，改用完整查询名
+			minimise = false
+			continue
+		}
+		if child := r.referral(resp, d.zone, qname); child != nil {
+			if qtype == dns.TypeDS && child.zone == name {
+				// 父区域未签名，委派即表示没有 DS 记录
+				return resp, nil
+			}
+			r.o.infra.setDelegation(child)
+			d, known = child, dns.CountLabel(child.zone)
+			continue
+		}
+		if qname == name {
+			resp.Answer = inBailiwick(resp.Answer, d.zone)
+			resp.Ns = inBailiwick(resp.Ns, d.zone)
+			return resp, nil
+		}
+		if resp.Rcode == dns.RcodeNameError {
+			// 部分服务器对空非终端返回 NXDOMAIN，改用完整查询名确认
+			minimise = false
+			continue
+		}
+		known++
+	}
+}
+
+// closest 查找 name 最近的已缓存委派，没有时从根服务器开始
+func (r *resolver) closest(name string) *delegation {
+	for z := name; z != "."; z = parent(z) {
+		if d := r.o.infra.delegation(z); d != nil {
+			return d
+		}
+	}
+	return r.o.root
+}
+
+// referral 应答为向下一级区域的委派时返回该委派，只接受区域内的胶水记录
+func (r *resolver) referral(resp *dns.Msg, zone, qname string) *delegation {
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) > 0 {
+		return nil
+	}
+	var child *delegation
+	var ttl uint32
+	for _, rr := range resp.Ns {
+		switch rr := rr.(type) {
+		case *dns.SOA:
+			return nil
+		case *dns.NS:
+			owner := dns.CanonicalName(rr.Hdr.Name)
+			// 忽略向上或与查询名无关的委派
+			if owner == zone || !dns.IsSubDomain(zone, owner) || !dns.IsSubDomain(owner, qname) {
+				continue
+			}
+			if child == nil {
+				child, ttl = &delegation{zone: owner}, rr.Hdr.Ttl
+			}
+			if owner == child.zone {
+				child.ns = append(child.ns, dns.CanonicalName(rr.Ns))
+				ttl = min(ttl, rr.Hdr.Ttl)
+			}
+		}
+	}
+	if child == nil {
+		return nil
+	}
+	for _, rr := range resp.Extra {
+		owner := dns.CanonicalName(rr.Header().Name)
+		if !dns.IsSubDomain(zone, owner) || !slices.Contains(child.ns, owner) {
+			continue
+		}
+		switch rr := rr.(type) {
+		case *dns.A:
+			child.addrs = appendAddr(child.addrs, rr.A)
+		case *dns.AAAA:
+			child.addrs = appendAddr(child.addrs, rr.AAAA)
+		}
+	}
+	child.expire = time.Now().Add(infraTTL(ttl))
+	return child
+}
+
+// query 依次向区域的权威服务器发送请求，直到得到有效应答
+func (r *resolver) query(d *delegation, qname string, qtype uint16, depth int) (*dns.Msg, error) {
+	addrs := r.servers(d, depth)
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%w for %s", errNoServer, d.zone)
+	}
+	var lastErr error
+	for _, i := range rand.Perm(len(addrs)) {
+		if r.queries >= r.o.opts.MaxQueries {
+			return nil, errMaxQueries
+		}
+		if time.Now().After(r.deadline) {
+			return nil, errTimeout
+		}
+		r.queries++
+		resp, err := r.exchange(addrs[i], qname, qtype)
+		switch {
+		case err != nil:
+			lastErr = err
+		case resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError:
+			lastErr = fmt.Errorf("%s from %s", dns.RcodeToString[resp.Rcode], addrs[i])
+		case lame(resp, d.zone):
+			lastErr = fmt.Errorf("lame delegation for %s at %s", d.zone, addrs[i])
+		default:
+			return resp, nil
+		}
+	}
+	return nil, lastErr
+}
+
+// lame 服务器不是该区域的权威服务器：非权威的空应答且没有向下的委派
+func lame(resp *dns.Msg, zone string) bool {
+	if resp.Authoritative || len(resp.Answer) > 0 || resp.Rcode != dns.RcodeSuccess {
+		return false
+	}
+	for _, rr := range resp.Ns {
+		switch rr.Header().Rrtype {
+		case dns.TypeSOA:
+			return false
+		case dns.TypeNS:
+			if owner := rr.Header().Name; !strings.EqualFold(owner, zone) && dns.IsSubDomain(zone, owner) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// servers 权威服务器地址，没有胶水记录时解析 NS 主机名
+func (r *resolver) servers(d *delegation, depth int) []netip.Addr {
+	if addrs := r.usable(d.addrs); len(addrs) > 0 {
+		return addrs
+	}
+	for _, i := range rand.Perm(len(d.ns)) {
+		ns := d.ns[i]
+		// 区域内的服务器没有胶水记录时无法解析
+		if dns.IsSubDomain(d.zone, ns) {
+			continue
+		}
+		if addrs := r.usable(r.host(ns, depth)); len(addrs) > 0 {
+			return addrs
+		}
+	}
+	return nil
+}
+
+// host 解析权威服务器主机名的地址，结果（包括不存在的主机名）写入基础设施缓存
+func (r *resolver) host(name string, depth int) []netip.Addr {
+	if addrs, ok := r.o.infra.host(name); ok {
+		return addrs
+	}
+	qtypes := []uint16{dns.TypeA}
+	if r.o.opts.IPv6 {
+		qtypes = append(qtypes, dns.TypeAAAA)
+	}
+	var addrs []netip.Addr
+	var ttl uint32
+	complete := true
+	for _, qtype := range qtypes {
+		resp, err := r.resolve(name, qtype, depth+1)
+		if err != nil {
+			// 受本次查询限制的失败不写入缓存
+			complete = false
+			continue
+		}
+		// 只使用主机名（或其别名目标）的地址记录
+		owner := name
+		for range maxChain {
+			next := alias(resp.Answer, owner)
+			if next == "" {
+				break
+			}
+			owner = next
+		}
+		for _, rr := range resp.Answer {
+			if !strings.EqualFold(rr.Header().Name, owner) {
+				continue
+			}
+			switch rr := rr.(type) {
+			case *dns.A:
+				addrs = appendAddr(addrs, rr.A)
+			case *dns.AAAA:
+				addrs = appendAddr(addrs, rr.AAAA)
+			default:
+				continue
+			}
+			if ttl == 0 || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+		}
+	}
+	if complete || len(addrs) > 0 {
+		r.o.infra.setHost(name, addrs, infraTTL(ttl))
+	}
+	return addrs
+}
+
+// usable 过滤未启用的 IPv6 地址
+func (r *resolver) usable(addrs []netip.Addr) []netip.Addr {
+	if r.o.opts.IPv6 {
+		return addrs
+	}
+	var v4 []netip.Addr
+	for _, addr := range addrs {
+		if addr.Is4() {
+			v4 = append(v4, addr)
+		}
+	}
+	return v4
+}
+
+// exchange 向权威服务器发送非递归请求，截断时改用 TCP
+func (r *resolver) exchange(addr netip.Addr, qname string, qtype uint16) (*dns.Msg, error) {
+	req := new(dns.Msg)
+	req.SetQuestion(qname, qtype)
+	req.Question[0].Qclass = r.qclass
+	req.RecursionDesired = false
+	req.SetEdns0(ednsSize, r.do)
+
+	server := netip.AddrPortFrom(addr, r.o.opts.Port).String()
+	timeout := min(r.o.opts.Timeout, time.Until(r.deadline))
+	client := &dns.Client{Net: "udp", Timeout: timeout, UDPSize: ednsSize}
+	resp, _, err := client.Exchange(req, server)
+	if err == nil && resp.Truncated {
+		client.Net = "tcp"
+		resp, _, err = client.Exchange(req, server)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Question) != 1 || !strings.EqualFold(resp.Question[0].Name, qname) || resp.Question[0].Qtype != qtype {
+		return nil, fmt.Errorf("%w from %s", errMismatch, addr)
+	}
+	return resp, nil
+}
+
+func parent(name string) string {
+	if i, end := dns.NextLabel(name, 0); !end {
+		return name[i:]
+	}
+	return "."
+}
+
+// ancestor name 保留 n 个标签的上级名称
+func ancestor(name string, n int) string {
+	labels := dns.SplitDomainName(name)
+	return dns.Fqdn(strings.Join(labels[len(labels)-n:], "."))
+}
@@ -0,0 +1,368 @@
+package recursive
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// 本地权威服务器，每个地址加载若干区域；inject 为附加到应答区的记录，模拟越权的应答
+var authServers = []struct {
+	addr   string
+	zones  string
+	inject map[string]string
+}{
+	{addr: "127.0.0.10", zones: `
+. 3600 IN SOA a.root. h.root. 1 2 3 4 60
+. 3600 IN NS a.root.
+a.root. 3600 IN A 127.0.0.10
+test. 3600 IN NS ns1.test.
+ns1.test. 3600 IN A 127.0.0.11
+other. 3600 IN NS ns.other.
+ns.other. 3600 IN A 127.0.0.13
+cdn. 3600 IN NS ns.cdn.other.
+glueless. 3600 IN NS ns.glueless.other.
+d1. 3600 IN NS ns.d2.
+d2. 3600 IN NS ns.d3.
+d3. 3600 IN NS ns.d4.
+d4. 3600 IN NS ns.d5.
+d5. 3600 IN NS ns.d6.
+d6. 3600 IN NS ns.d7.
+d7. 3600 IN NS ns.d7.
+ns.d7. 3600 IN A 127.0.0.15
+`},
+	{addr: "127.0.0.11", zones: `
+test. 3600 IN SOA ns1.test. h.test. 1 2 3 4 60
+test. 3600 IN NS ns1.test.
+ns1.test. 3600 IN A 127.0.0.11
+www.test. 300 IN A 192.0.2.1
+inzone.test. 300 IN CNAME www.test.
+alias.test. 300 IN CNAME www.cdn.
+poison.test. 300 IN CNAME www.cdn.
+dn.test. 300 IN DNAME cdn.
+loop.test. 300 IN CNAME loop2.test.
+loop2.test. 300 IN CNAME loop.test.
+sub.test. 3600 IN NS ns.sub.test.
+ns.sub.test. 3600 IN A 127.0.0.12
+`, inject: map[string]string{
+		"poison.test.": "www.cdn. 300 IN A 198.51.100.66",
+	}},
+	{addr: "127.0.0.12", zones: `
+sub.test. 3600 IN SOA ns.sub.test. h.test. 1 2 3 4 60
+sub.test. 3600 IN NS ns.sub.test.
+a.b.c.sub.test. 300 IN A 192.0.2.2
+`},
+	{addr: "127.0.0.13", zones: `
+other. 3600 IN SOA ns.other. h.other. 1 2 3 4 60
+other. 3600 IN NS ns.other.
+ns.cdn.other. 3600 IN A 127.0.0.14
+ns.glueless.other. 3600 IN TXT "no address"
+`, inject: map[string]string{
+		"ns.glueless.other.": "evil.other. 300 IN A 127.0.0.14",
+	}},
+	{addr: "127.0.0.14", zones: `
+cdn. 3600 IN SOA ns.cdn.other. h.other. 1 2 3 4 60
+cdn. 3600 IN NS ns.cdn.other.
+www.cdn. 300 IN A 192.0.2.3
+glueless. 3600 IN SOA ns.glueless.other. h.other. 1 2 3 4 60
+glueless. 3600 IN NS ns.glueless.other.
+www.glueless. 300 IN A 192.0.2.4
+`},
+	{addr: "127.0.0.15", zones: `
+d1. 3600 IN SOA ns.d2. h. 1 2 3 4 60
+d2. 3600 IN SOA ns.d3. h. 1 2 3 4 60
+d3. 3600 IN SOA ns.d4. h. 1 2 3 4 60
+d4. 3600 IN SOA ns.d5. h. 1 2 3 4 60
+d5. 3600 IN SOA ns.d6. h. 1 2 3 4 60
+d6. 3600 IN SOA ns.d7. h. 1 2 3 4 60
+d7. 3600 IN SOA ns.d7. h. 1 2 3 4 60
+ns.d2. 60 IN A 127.0.0.15
+ns.d3. 60 IN A 127.0.0.15
+ns.d4. 60 IN A 127.0.0.15
+ns.d5. 60 IN A 127.0.0.15
+ns.d6. 60 IN A 127.0.0.15
+ns.d7. 60 IN A 127.0.0.15
+www.d1. 60 IN A 192.0.2.5
+`},
+}
+
+type authZone struct {
+	apex string
+	soa  dns.RR
+	rrs  []dns.RR
+}
+
+// authServer 简化的权威服务器：委派、CNAME/DNAME（不合成 CNAME）与否定应答
+type authServer struct {
+	zones  []*authZone
+	inject map[string][]dns.RR
+
+	access sync.Mutex
+	// 收到的查询，"名称 类型"
+	log []string
+}
+
+func newAuthServer(zones string, inject map[string]string) (*authServer, error) {
+	s := &authServer{inject: make(map[string][]dns.RR)}
+	var rrs []dns.RR
+	zp := dns.NewZoneParser(strings.NewReader(zones), ".", "")
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rrs = append(rrs, rr)
+		if soa, ok := rr.(*dns.SOA); ok {
+			s.zones = append(s.zones, &authZone{apex: soa.Hdr.Name, soa: soa})
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	for _, rr := range rrs {
+		if z := s.zone(rr.Header().Name); z != nil {
+			z.rrs = append(z.rrs, rr)
+		}
+	}
+	for name, text := range inject {
+		rr, err := dns.NewRR(text)
+		if err != nil {
+			return nil, err
+		}
+		s.inject[name] = append(s.inject[name], rr)
+	}
+	return s, nil
+}
+
+// zone 查找 name 所在的最深区域
+func (s *authServer) zone(name string) *authZone {
+	var best *authZone
+	for _, z := range s.zones {
+		if dns.IsSubDomain(z.apex, name) && (best == nil || dns.CountLabel(z.apex) > dns.CountLabel(best.apex)) {
+			best = z
+		}
+	}
+	return best
+}
+
+func (s *authServer) queries() []string {
+	s.access.Lock()
+	defer s.access.Unlock()
+	return slices.Clone(s.log)
+}
+
+func (s *authServer) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	q := req.Question[0]
+	s.access.Lock()
+	s.log = append(s.log, q.Name+" "+dns.TypeToString[q.Qtype])
+	s.access.Unlock()
+
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	s.answer(resp, strings.ToLower(q.Name), q.Qtype)
+	resp.Answer = append(resp.Answer, s.inject[strings.ToLower(q.Name)]...)
+	w.WriteMsg(resp)
+}
+
+func (s *authServer) answer(resp *dns.Msg, name string, qtype uint16) {
+	z := s.zone(name)
+	if z == nil {
+		resp.Rcode = dns.RcodeRefused
+		return
+	}
+	for range maxChain {
+		// 委派
+		for _, rr := range z.rrs {
+			ns, ok := rr.(*dns.NS)
+			if !ok || ns.Hdr.Name == z.apex || !dns.IsSubDomain(ns.Hdr.Name, name) {
+				continue
+			}
+			resp.Ns = append(resp.Ns, ns)
+			for _, glue := range z.rrs {
+				if glue.Header().Rrtype == dns.TypeA && glue.Header().Name == ns.Ns {
+					resp.Extra = append(resp.Extra, glue)
+				}
+			}
+		}
+		if len(resp.Ns) > 0 {
+			return
+		}
+		resp.Authoritative = true
+		var exists bool
+		var target string
+		var found []dns.RR
+		for _, rr := range z.rrs {
+			owner := rr.Header().Name
+			if dns.IsSubDomain(name, owner) {
+				exists = true
+			}
+			switch rr := rr.(type) {
+			case *dns.CNAME:
+				if owner == name && qtype != dns.TypeCNAME {
+					resp.Answer = append(resp.Answer, rr)
+					target = rr.Target
+				}
+			case *dns.DNAME:
+				if owner != name && dns.IsSubDomain(owner, name) {
+					resp.Answer = append(resp.Answer, rr)
+					target = strings.TrimSuffix(name, owner) + rr.Target
+				}
+			}
+			if owner == name && rr.Header().Rrtype == qtype {
+				found = append(found, rr)
+			}
+		}
+		if len(found) > 0 {
+			resp.Answer = append(resp.Answer, found...)
+			return
+		}
+		if target == "" {
+			if !exists {
+				resp.Rcode = dns.RcodeNameError
+			}
+			resp.Ns = []dns.RR{z.soa}
+			return
+		}
+		// 区域内的目标继续查找
+		if name = target; !dns.IsSubDomain(z.apex, name) {
+			return
+		}
+	}
+}
+
+// fakeHierarchy 在同一端口的多个回环地址上启动权威服务器，返回根提示文件与端口
+func fakeHierarchy(t *testing.T) (map[string]*authServer, string, int) {
+	t.Helper()
+	servers := make(map[string]*authServer)
+	var conns []net.PacketConn
+	port := 0
+	for _, a := range authServers {
+		conn, err := net.ListenPacket("udp", net.JoinHostPort(a.addr, strconv.Itoa(port)))
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			t.Skipf("listen %s: %v", a.addr, err)
+		}
+		conns = append(conns, conn)
+		port = conn.LocalAddr().(*net.UDPAddr).Port
+
+		s, err := newAuthServer(a.zones, a.inject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		servers[a.addr] = s
+		srv := &dns.Server{PacketConn: conn, Handler: s}
+		go srv.ActivateAndServe()
+		t.Cleanup(func() { srv.Shutdown() })
+	}
+	hints := filepath.Join(t.TempDir(), "named.root")
+	if err := os.WriteFile(hints, []byte(". 3600 IN NS a.root.\na.root. 3600 IN A 127.0.0.10\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return servers, hints, port
+}
+
+func lookup(t *testing.T, hints string, port int, params, name string, qtype uint16) (*dns.Msg, error) {
+	t.Helper()
+	query, err := url.ParseQuery(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query.Set("hints", hints)
+	query.Set("port", strconv.Itoa(port))
+	query.Set("timeout", "500ms")
+	o, err := NewOutbound("recursive", query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := new(dns.Msg)
+	req.SetQuestion(name, qtype)
+	resp, _, err := o.Exchange(req)
+	return resp, err
+}
+
+// addrs 应答中查询名（沿别名）最终的地址
+func addrs(resp *dns.Msg) []string {
+	var out []string
+	for _, rr := range resp.Answer {
+		if a, ok := rr.(*dns.A); ok {
+			out = append(out, a.Hdr.Name+" "+a.A.String())
+		}
+	}
+	return out
+}
+
+func TestResolve(t *testing.T) {
+	_, hints, port := fakeHierarchy(t)
+	tests := []struct {
+		name   string
+		params string
+		qname  string
+		want   []string
+		rcode  int
+		err    error
+	}{
+		{name: "referral with glue", qname: "www.test.", want: []string{"www.test. 192.0.2.1"}},
+		{name: "glueless ns", qname: "www.cdn.", want: []string{"www.cdn. 192.0.2.3"}},
+		{name: "in-zone cname", qname: "inzone.test.", want: []string{"www.test. 192.0.2.1"}},
+		{name: "out-of-zone cname", qname: "alias.test.", want: []string{"www.cdn. 192.0.2.3"}},
+		{name: "out-of-bailiwick answer", qname: "poison.test.", want: []string{"www.cdn. 192.0.2.3"}},
+		{name: "dname without cname", qname: "www.dn.test.", want: []string{"www.cdn. 192.0.2.3"}},
+		{name: "cname loop", qname: "loop.test.", err: errCNAMELoop},
+		{name: "nxdomain", qname: "nx.test.", rcode: dns.RcodeNameError},
+		{name: "ns host owner mismatch", qname: "www.glueless.", err: errNoServer},
+		{name: "minimised referral", qname: "a.b.c.sub.test.", want: []string{"a.b.c.sub.test. 192.0.2.2"}},
+		{name: "depth within limit", params: "maxDepth=5", qname: "www.d1.", want: []string{"www.d1. 192.0.2.5"}},
+		{name: "depth limit", params: "maxDepth=3", qname: "www.d1.", err: errNoServer},
+		{name: "query limit", params: "maxQueries=3", qname: "a.b.c.sub.test.", err: errMaxQueries},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := lookup(t, hints, port, tt.params, tt.qname, dns.TypeA)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.Rcode != tt.rcode {
+				t.Fatalf("rcode = %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if got := addrs(resp); !slices.Equal(got, tt.want) {
+				t.Fatalf("answer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQnameMinimisation(t *testing.T) {
+	servers, hints, port := fakeHierarchy(t)
+	for _, minimise := range []bool{true, false} {
+		t.Run(fmt.Sprintf("qnameMin=%v", minimise), func(t *testing.T) {
+			root, tld := servers["127.0.0.10"], servers["127.0.0.11"]
+			rootSeen, tldSeen := len(root.queries()), len(tld.queries())
+			if _, err := lookup(t, hints, port, fmt.Sprintf("qnameMin=%v", minimise), "a.b.c.sub.test.", dns.TypeA); err != nil {
+				t.Fatal(err)
+			}
+			want := map[*authServer]string{root: "test. A", tld: "sub.test. A"}
+			if !minimise {
+				want = map[*authServer]string{root: "a.b.c.sub.test. A", tld: "a.b.c.sub.test. A"}
+			}
+			for s, seen := range map[*authServer]int{root: rootSeen, tld: tldSeen} {
+				if got := s.queries()[seen:]; !slices.Equal(got, []string{want[s]}) {
+					t.Fatalf("queries = %v, want %v", got, []string{want[s]})
+				}
+			}
+		})
+	}
+}
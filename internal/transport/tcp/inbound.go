@@ -15,13 +15,16 @@ import (
 	"time"
 
 	"github.com/miekg/dns"
-	"github.com/taodev/godns/internal/route"
+	"github.com/taodev/godns/internal/adapter"
+	"github.com/taodev/godns/internal/metrics"
 	"github.com/taodev/godns/internal/utils"
 	"github.com/taodev/stcp"
 )
 
 const (
 	defaultTimeout = 120 * time.Second
+	// 单个连接同时处理的请求数，达到上限后暂停读取
+	maxInflight = 64
 )
 
 type Options struct {
@@ -34,12 +37,12 @@ type Options struct {
 type Inbound struct {
 	options  *Options
 	listener net.Listener
-	router   *route.Router
+	router   adapter.Router
 	wait     sync.WaitGroup
 	running  atomic.Bool
 }
 
-func NewInbound(ctx context.Context, router *route.Router, options *Options) *Inbound {
+func NewInbound(ctx context.Context, router adapter.Router, options *Options) *Inbound {
 	return &Inbound{
 		router:  router,
 		options: options,
@@ -114,14 +117,23 @@ func (h *Inbound) handleAccept() {
 }
 
 func (h *Inbound) handleConn(conn net.Conn) {
-	defer conn.Close()
 	var (
-		err  error
-		req  *dns.Msg
-		resp *dns.Msg
+		err     error
+		req     *dns.Msg
+		writeMu sync.Mutex
+		pending sync.WaitGroup
+		// 在途请求数上限
+		inflight = make(chan struct{}, maxInflight)
 	)
+	metrics.ConnOpened(h.options.Type)
+	defer func() {
+		pending.Wait()
+		conn.Close()
+		metrics.ConnClosed(h.options.Type)
+	}()
+	raddr, _ := netip.ParseAddrPort(conn.RemoteAddr().String())
 	for h.running.Load() {
-		if err = conn.SetDeadline(time.Now().Add(3 * time.Minute)); err != nil {
+		if err = conn.SetReadDeadline(time.Now().Add(3 * time.Minute)); err != nil {
 			return
 		}
 		if req, err = read(conn); err != nil {
@@ -131,12 +143,26 @@ func (h *Inbound) handleConn(conn net.Conn) {
 			slog.Info("recv ping", "addr", conn.RemoteAddr())
 			continue
 		}
-		raddr, _ := netip.ParseAddrPort(conn.RemoteAddr().String())
-		if resp, err = h.router.Exchange(req, h.options.Type, raddr.Addr().String()); err != nil {
-			resp = utils.NewMsgSERVFAIL(req)
-		}
-		if err = write(conn, resp); err != nil {
-			return
-		}
+		// 并发处理流水线请求，响应可乱序返回（RFC 7766 Section 6.2.1.1）
+		inflight <- struct{}{}
+		pending.Add(1)
+		go func(req *dns.Msg) {
+			defer func() {
+				<-inflight
+				pending.Done()
+			}()
+			resp, err := h.router.Exchange(req, h.options.Type, raddr.Addr().String())
+			if err != nil {
+				resp = utils.NewMsgSERVFAIL(req)
+			}
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			if err = conn.SetWriteDeadline(time.Now().Add(defaultTimeout)); err != nil {
+				return
+			}
+			if err = write(conn, resp); err != nil {
+				conn.Close()
+			}
+		}(req)
 	}
 }
@@ -7,8 +7,6 @@ import (
 	"log/slog"
 	"net"
 	"net/url"
-	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
@@ -23,17 +21,6 @@ type dialer interface {
 	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
 }
 
-type asyncResp struct {
-	resp *dns.Msg
-	rtt  time.Duration
-	err  error
-}
-
-type asyncRequest struct {
-	req *dns.Msg
-	ch  chan *asyncResp
-}
-
 type Outbound struct {
 	tag      string
 	typ      string
@@ -41,21 +28,21 @@ type Outbound struct {
 	hostname string
 	dialer   dialer
 
-	keepAlive bool
-	requestCh chan *asyncRequest
-	closeCh   chan struct{}
-	connected atomic.Bool
-	conn      net.Conn
-	once      sync.Once
-	wait      sync.WaitGroup
+	opts PoolOptions
+	pool *connPool
 }
 
-func NewOutbound(tag, typ, addr string, hostname string, privateKey, serverPub string) adapter.Outbound {
+func NewOutbound(tag, typ, addr string, hostname string, query url.Values) (outbound adapter.Outbound, err error) {
+	opts, err := parsePoolOptions(query)
+	if err != nil {
+		return nil, err
+	}
 	out := &Outbound{
 		tag:      tag,
 		typ:      typ,
 		addr:     addr,
 		hostname: hostname,
+		opts:     opts,
 	}
 	switch typ {
 	case "tcp":
@@ -63,11 +50,15 @@ func NewOutbound(tag, typ, addr string, hostname string, privateKey, serverPub s
 	case "tls":
 		out.dialer = &tls.Dialer{
 			Config: &tls.Config{
-				ServerName: hostname,
+				ServerName:         hostname,
+				ClientSessionCache: tls.NewLRUClientSessionCache(0),
 			},
 		}
 	}
-	return out
+	if opts.KeepAlive {
+		out.pool = newConnPool(out.dialer, addr, opts, false)
+	}
+	return out, nil
 }
 
 func NewOutboundSTCP(tag, addr string, defaultKey string) (outbound adapter.Outbound, err error) {
@@ -114,20 +105,22 @@ func NewOutboundSTCP(tag, addr string, defaultKey string) (outbound adapter.Outb
 		return nil, err
 	}
 
-	out := &Outbound{
-		tag:       tag,
-		typ:       utils.TypeSTCP,
-		addr:      net.JoinHostPort(ip, port),
-		hostname:  host,
-		dialer:    &stcp.Dialer{Config: config},
-		keepAlive: u.Query().Get("keepAlive") == "true",
+	opts, err := parsePoolOptions(u.Query())
+	if err != nil {
+		return nil, err
 	}
 
-	if out.keepAlive {
-		out.requestCh = make(chan *asyncRequest, 128)
-		out.closeCh = make(chan struct{})
-		out.wait.Add(1)
-		go out.requestLoop()
+	out := &Outbound{
+		tag:      tag,
+		typ:      utils.TypeSTCP,
+		addr:     net.JoinHostPort(ip, port),
+		hostname: host,
+		dialer:   &stcp.Dialer{Config: config},
+		opts:     opts,
+	}
+	if opts.KeepAlive {
+		// STCP 服务端支持空消息心跳
+		out.pool = newConnPool(out.dialer, out.addr, opts, true)
 	}
 
 	return out, nil
@@ -142,32 +135,27 @@ func (h *Outbound) Type() string {
 }
 
 func (h *Outbound) Exchange(in *dns.Msg) (resp *dns.Msg, rtt time.Duration, err error) {
-	if !h.keepAlive {
+	if h.pool == nil {
 		return h.exchange(in)
 	}
 
-	req := &asyncRequest{req: in, ch: make(chan *asyncResp)}
-	h.requestCh <- req
-	select {
-	case <-h.closeCh:
-		return nil, 0, fmt.Errorf("closed")
-	case r := <-req.ch:
-		return r.resp, r.rtt, r.err
+	now := time.Now()
+	if resp, err = h.pool.exchange(in); err != nil {
+		return nil, time.Since(now), err
 	}
+	return resp, time.Since(now), nil
 }
 
 func (h *Outbound) exchange(in *dns.Msg) (resp *dns.Msg, rtt time.Duration, err error) {
 	now := time.Now()
-	conn, err := h.dial(h.addr)
+	ctx, cancel := context.WithTimeout(context.Background(), h.opts.Timeout)
+	defer cancel()
+	conn, err := h.dialer.DialContext(ctx, "tcp", h.addr)
 	if err != nil {
 		return nil, time.Since(now), err
 	}
-	defer func() {
-		if !h.keepAlive {
-			conn.Close()
-		}
-	}()
-	if err = conn.SetDeadline(time.Now().Add(defaultTimeout)); err != nil {
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(h.opts.Timeout)); err != nil {
 		return nil, time.Since(now), err
 	}
 	if err = write(conn, in); err != nil {
@@ -181,80 +169,8 @@ func (h *Outbound) exchange(in *dns.Msg) (resp *dns.Msg, rtt time.Duration, err
 	return resp, time.Since(now), nil
 }
 
-func (h *Outbound) requestLoop() {
-	defer h.wait.Done()
-
-	slog.Info("requestLoop", "tag", h.tag)
-	h.dial(h.addr)
-
-	ticker := time.NewTicker(time.Minute)
-	for {
-		select {
-		case <-h.closeCh:
-			return
-		case <-ticker.C:
-			conn, err := h.dial(h.addr)
-			if err != nil {
-				slog.Error("dial failed", "error", err)
-				h.dial(h.addr)
-				continue
-			}
-			if err = h.ping(conn); err != nil {
-				slog.Error("ping failed", "error", err)
-				h.connected.Store(false)
-				conn.Close()
-				h.dial(h.addr)
-				continue
-			}
-			slog.Debug("ping", "tag", h.tag)
-		case req := <-h.requestCh:
-			resp, rtt, err := h.exchange(req.req)
-			req.ch <- &asyncResp{resp: resp, rtt: rtt, err: err}
-		}
-	}
-}
-
-func (h *Outbound) dial(addr string) (net.Conn, error) {
-	if !h.keepAlive {
-		return h.dialer.DialContext(context.Background(), "tcp", addr)
-	}
-
-	if h.connected.Load() {
-		return h.conn, nil
-	}
-
-	conn, err := h.dialer.DialContext(context.Background(), "tcp", addr)
-	if err != nil {
-		return nil, err
-	}
-	h.conn = conn
-	h.connected.Store(true)
-	return conn, nil
-}
-
-func (h *Outbound) ping(conn net.Conn) (err error) {
-	if !h.keepAlive {
-		return nil
-	}
-	if err = conn.SetDeadline(time.Now().Add(defaultTimeout)); err != nil {
-		return err
-	}
-	// 发送心跳包
-	if _, err = conn.Write([]byte{0x00, 0x00}); err != nil {
-		return err
-	}
-	return nil
-}
-
 func (h *Outbound) Close() {
-	h.once.Do(func() {
-		if h.keepAlive {
-			close(h.closeCh)
-			h.connected.Store(false)
-			h.wait.Wait()
-			if h.conn != nil {
-				h.conn.Close()
-			}
-		}
-	})
+	if h.pool != nil {
+		h.pool.close()
+	}
 }
@@ -0,0 +1,317 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultPoolSize = 2
+	// 心跳间隔（仅 STCP）
+	pingInterval = time.Minute
+	// 连续超时多少次后关闭连接，由连接池重新建立
+	maxTimeouts = 3
+)
+
+var errConnClosed = errors.New("connection closed")
+
+// PoolOptions 长连接池配置，来自 outbound URL 的查询参数，例如：
+//
+//	tls://dns.alidns.com?keepAlive=true&poolSize=2&timeout=10s
+type PoolOptions struct {
+	// 是否复用长连接
+	KeepAlive bool
+	// 连接数
+	PoolSize int
+	// 单次请求超时
+	Timeout time.Duration
+}
+
+func parsePoolOptions(query url.Values) (opts PoolOptions, err error) {
+	opts = PoolOptions{
+		KeepAlive: query.Get("keepAlive") == "true",
+		PoolSize:  defaultPoolSize,
+		Timeout:   defaultTimeout,
+	}
+	if v := query.Get("poolSize"); v != "" {
+		if opts.PoolSize, err = strconv.Atoi(v); err != nil || opts.PoolSize <= 0 {
+			return opts, fmt.Errorf("invalid poolSize: %s", v)
+		}
+	}
+	if v := query.Get("timeout"); v != "" {
+		if opts.Timeout, err = time.ParseDuration(v); err != nil {
+			return opts, fmt.Errorf("invalid timeout: %w", err)
+		}
+	}
+	return opts, nil
+}
+
+// pipeConn 一条支持流水线的连接（RFC 7766 Section 6.2.1.1），
+// 多个请求可同时在途，响应按消息 ID 匹配
+type pipeConn struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	access  sync.Mutex
+	pending map[uint16]chan *dns.Msg
+	nextID  uint16
+	err     error
+	closeCh chan struct{}
+	// 连续超时次数，收到响应时清零
+	timeouts atomic.Int32
+}
+
+func newPipeConn(conn net.Conn, ping bool) *pipeConn {
+	pc := &pipeConn{
+		conn:    conn,
+		pending: make(map[uint16]chan *dns.Msg),
+		closeCh: make(chan struct{}),
+	}
+	go pc.readLoop()
+	if ping {
+		go pc.pingLoop()
+	}
+	return pc
+}
+
+func (pc *pipeConn) alive() bool {
+	select {
+	case <-pc.closeCh:
+		return false
+	default:
+		return true
+	}
+}
+
+// register 分配一个连接内唯一的消息 ID
+func (pc *pipeConn) register() (id uint16, ch chan *dns.Msg, err error) {
+	pc.access.Lock()
+	defer pc.access.Unlock()
+	if pc.err != nil {
+		return 0, nil, errConnClosed
+	}
+	if len(pc.pending) >= math.MaxUint16 {
+		return 0, nil, fmt.Errorf("too many pending requests")
+	}
+	for {
+		pc.nextID++
+		if _, ok := pc.pending[pc.nextID]; !ok {
+			break
+		}
+	}
+	ch = make(chan *dns.Msg, 1)
+	pc.pending[pc.nextID] = ch
+	return pc.nextID, ch, nil
+}
+
+func (pc *pipeConn) unregister(id uint16) {
+	pc.access.Lock()
+	delete(pc.pending, id)
+	pc.access.Unlock()
+}
+
+func (pc *pipeConn) exchange(in *dns.Msg, timeout time.Duration) (resp *dns.Msg, err error) {
+	id, ch, err := pc.register()
+	if err != nil {
+		return nil, err
+	}
+	defer pc.unregister(id)
+
+	req := in.Copy()
+	req.Id = id
+	pc.writeMu.Lock()
+	err = pc.conn.SetWriteDeadline(time.Now().Add(timeout))
+	if err == nil {
+		err = write(pc.conn, req)
+	}
+	pc.writeMu.Unlock()
+	if err != nil {
+		pc.close(err)
+		return nil, errConnClosed
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case resp = <-ch:
+		pc.timeouts.Store(0)
+		resp.Id = in.Id
+		return resp, nil
+	case <-pc.closeCh:
+		return nil, errConnClosed
+	case <-timer.C:
+		// 连接可能已经失效（如中间设备丢弃了连接），连续超时后关闭
+		if pc.timeouts.Add(1) >= maxTimeouts {
+			slog.Debug("close connection after consecutive timeouts", "addr", pc.conn.RemoteAddr())
+			pc.close(errors.New("consecutive timeouts"))
+		}
+		return nil, fmt.Errorf("exchange timeout")
+	}
+}
+
+func (pc *pipeConn) readLoop() {
+	for {
+		resp, err := read(pc.conn)
+		if err != nil {
+			pc.close(err)
+			return
+		}
+		if resp == nil {
+			continue
+		}
+		pc.access.Lock()
+		ch, ok := pc.pending[resp.Id]
+		delete(pc.pending, resp.Id)
+		pc.access.Unlock()
+		if ok {
+			ch <- resp
+		} else {
+			slog.Debug("unexpected response id", "id", resp.Id, "addr", pc.conn.RemoteAddr())
+		}
+	}
+}
+
+func (pc *pipeConn) pingLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pc.closeCh:
+			return
+		case <-ticker.C:
+			pc.writeMu.Lock()
+			err := pc.conn.SetWriteDeadline(time.Now().Add(defaultTimeout))
+			if err == nil {
+				// 发送心跳包
+				_, err = pc.conn.Write([]byte{0x00, 0x00})
+			}
+			pc.writeMu.Unlock()
+			if err != nil {
+				slog.Error("ping failed", "addr", pc.conn.RemoteAddr(), "error", err)
+				pc.close(err)
+				return
+			}
+		}
+	}
+}
+
+func (pc *pipeConn) close(err error) {
+	pc.access.Lock()
+	defer pc.access.Unlock()
+	if pc.err != nil {
+		return
+	}
+	pc.err = err
+	close(pc.closeCh)
+	pc.conn.Close()
+}
+
+// connPool 固定大小的长连接池，请求轮询分配到各连接上，连接失效后自动重连
+type connPool struct {
+	dialer dialer
+	addr   string
+	opts   PoolOptions
+	ping   bool
+	next   atomic.Uint32
+	access sync.Mutex
+	conns  []*pipeConn
+	// 正在建立的连接，同一位置的请求等待同一次拨号
+	dials  []*poolDial
+	closed bool
+}
+
+// poolDial 一次拨号，完成后关闭 done
+type poolDial struct {
+	done chan struct{}
+	pc   *pipeConn
+	err  error
+}
+
+func newConnPool(d dialer, addr string, opts PoolOptions, ping bool) *connPool {
+	return &connPool{
+		dialer: d,
+		addr:   addr,
+		opts:   opts,
+		ping:   ping,
+		conns:  make([]*pipeConn, opts.PoolSize),
+		dials:  make([]*poolDial, opts.PoolSize),
+	}
+}
+
+// get 取得第 i 个连接，连接失效时在锁外拨号，避免阻塞其他位置的请求
+func (p *connPool) get() (*pipeConn, error) {
+	i := int(p.next.Add(1)) % len(p.conns)
+	p.access.Lock()
+	if p.closed {
+		p.access.Unlock()
+		return nil, errConnClosed
+	}
+	if pc := p.conns[i]; pc != nil && pc.alive() {
+		p.access.Unlock()
+		return pc, nil
+	}
+	if d := p.dials[i]; d != nil {
+		p.access.Unlock()
+		<-d.done
+		return d.pc, d.err
+	}
+	d := &poolDial{done: make(chan struct{})}
+	p.dials[i] = d
+	p.access.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.opts.Timeout)
+	conn, err := p.dialer.DialContext(ctx, "tcp", p.addr)
+	cancel()
+
+	p.access.Lock()
+	p.dials[i] = nil
+	switch {
+	case err != nil:
+		d.err = err
+	case p.closed:
+		conn.Close()
+		d.err = errConnClosed
+	default:
+		d.pc = newPipeConn(conn, p.ping)
+		p.conns[i] = d.pc
+	}
+	p.access.Unlock()
+	close(d.done)
+	return d.pc, d.err
+}
+
+func (p *connPool) exchange(in *dns.Msg) (resp *dns.Msg, err error) {
+	// 连接在请求发出前后失效时透明重连一次
+	for range 2 {
+		var pc *pipeConn
+		if pc, err = p.get(); err != nil {
+			return nil, err
+		}
+		if resp, err = pc.exchange(in, p.opts.Timeout); !errors.Is(err, errConnClosed) {
+			return resp, err
+		}
+	}
+	return nil, err
+}
+
+func (p *connPool) close() {
+	p.access.Lock()
+	defer p.access.Unlock()
+	p.closed = true
+	for _, pc := range p.conns {
+		if pc != nil {
+			pc.close(errConnClosed)
+		}
+	}
+}
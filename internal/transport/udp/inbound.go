@@ -1,6 +1,7 @@
 package udp
 
 import (
+	"bytes"
 	"context"
 	"log/slog"
 	"net"
@@ -61,7 +62,8 @@ func (i *Inbound) serve() {
 			}
 			return
 		}
-		go i.handlePacket(buf[:n], addr)
+		// buf 会被下一次读取覆盖
+		go i.handlePacket(bytes.Clone(buf[:n]), addr)
 	}
 }
 
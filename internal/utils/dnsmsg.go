@@ -73,3 +73,17 @@ func reply(req *dns.Msg, code int) (resp *dns.Msg) {
 	resp.SetRcode(req, code)
 	return
 }
+
+// SetEDE 请求支持 EDNS 时在应答中添加扩展错误（RFC 8914）
+func SetEDE(resp, req *dns.Msg, code uint16) {
+	reqOpt := req.IsEdns0()
+	if reqOpt == nil {
+		return
+	}
+	opt := resp.IsEdns0()
+	if opt == nil {
+		resp.SetEdns0(reqOpt.UDPSize(), reqOpt.Do())
+		opt = resp.IsEdns0()
+	}
+	opt.Option = append(opt.Option, &dns.EDNS0_EDE{InfoCode: code})
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ByteSize 字节数，配置中支持 512B、512KB、64MB、1GB 等写法，必须带单位
+type ByteSize int64
+
+const (
+	KB ByteSize = 1 << (10 * (iota + 1))
+	MB
+	GB
+)
+
+var sizeUnits = []struct {
+	suffix string
+	size   ByteSize
+}{
+	{"GB", GB}, {"G", GB},
+	{"MB", MB}, {"M", MB},
+	{"KB", KB}, {"K", KB},
+	{"B", 1},
+}
+
+// ParseByteSize 解析字节数
+func ParseByteSize(s string) (ByteSize, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	unit := ByteSize(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(str, u.suffix) {
+			str, unit = strings.TrimSpace(strings.TrimSuffix(str, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(str, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return ByteSize(n * float64(unit)), nil
+}
+
+// UnmarshalYAML 拒绝不带单位的数值，避免旧配置中按条目数或成本填写的值被当作字节数
+func (b *ByteSize) UnmarshalYAML(value *yaml.Node) (err error) {
+	if _, err := strconv.ParseFloat(strings.TrimSpace(value.Value), 64); err == nil {
+		return fmt.Errorf("line %d: size %q requires a unit (B, KB, MB, GB)", value.Line, value.Value)
+	}
+	*b, err = ParseByteSize(value.Value)
+	return err
+}
+
+func (b ByteSize) MarshalYAML() (any, error) {
+	return b.String(), nil
+}
+
+func (b ByteSize) String() string {
+	switch {
+	case b >= GB && b%GB == 0:
+		return fmt.Sprintf("%dGB", b/GB)
+	case b >= MB && b%MB == 0:
+		return fmt.Sprintf("%dMB", b/MB)
+	case b >= KB && b%KB == 0:
+		return fmt.Sprintf("%dKB", b/KB)
+	}
+	return fmt.Sprintf("%dB", int64(b))
+}
@@ -7,4 +7,8 @@ const (
 	TypeSTCP  = "stcp"
 	TypeHTTP  = "http"
 	TypeHTTPS = "https"
+	TypeQUIC  = "quic"
+	TypeH3    = "h3"
+	// 内置递归解析
+	TypeRecursive = "recursive"
 )
@@ -0,0 +1,291 @@
+package zone
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// 区域内最长 CNAME/DNAME 链
+const maxChain = 8
+
+// Zone 从区域文件加载的权威区域
+type Zone struct {
+	origin string
+	file   string
+	soa    *dns.SOA
+	// 名称 -> 类型 -> 记录，名称为小写
+	records map[string]map[uint16][]dns.RR
+	// 存在的名称，包括空非终端
+	names map[string]struct{}
+	count int
+}
+
+// Load 读取区域文件，origin 为区域名
+func Load(origin, file string) (*Zone, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f, origin, file)
+}
+
+// Parse 解析 RFC 1035 格式的区域，区域顶点必须有 SOA 记录
+func Parse(r io.Reader, origin, file string) (*Zone, error) {
+	origin = dns.CanonicalName(origin)
+	z := &Zone{
+		origin:  origin,
+		file:    file,
+		records: make(map[string]map[uint16][]dns.RR),
+		names:   make(map[string]struct{}),
+	}
+	zp := dns.NewZoneParser(r, origin, file)
+	zp.SetIncludeAllowed(true)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if err := z.add(rr); err != nil {
+			return nil, err
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	if z.soa == nil {
+		return nil, fmt.Errorf("zone %s: missing SOA at apex", origin)
+	}
+	for name, types := range z.records {
+		if len(types[dns.TypeCNAME]) == 0 {
+			continue
+		}
+		for t := range types {
+			switch t {
+			case dns.TypeCNAME, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+			default:
+				return nil, fmt.Errorf("zone %s: CNAME and other data at %s", origin, name)
+			}
+		}
+	}
+	return z, nil
+}
+
+func (z *Zone) add(rr dns.RR) error {
+	h := rr.Header()
+	h.Name = dns.CanonicalName(h.Name)
+	if h.Class != dns.ClassINET {
+		return fmt.Errorf("zone %s: unsupported class %s for %s", z.origin, dns.ClassToString[h.Class], h.Name)
+	}
+	if !dns.IsSubDomain(z.origin, h.Name) {
+		return fmt.Errorf("zone %s: %s is out of zone", z.origin, h.Name)
+	}
+	if soa, ok := rr.(*dns.SOA); ok {
+		if h.Name != z.origin || z.soa != nil {
+			return fmt.Errorf("zone %s: unexpected SOA at %s", z.origin, h.Name)
+		}
+		z.soa = soa
+	}
+	types, ok := z.records[h.Name]
+	if !ok {
+		types = make(map[uint16][]dns.RR)
+		z.records[h.Name] = types
+	}
+	types[h.Rrtype] = append(types[h.Rrtype], rr)
+	z.count++
+	// 记录所有上级名称，用于区分空非终端与不存在的名称
+	for name := h.Name; ; name = parent(name) {
+		z.names[name] = struct{}{}
+		if name == z.origin {
+			break
+		}
+	}
+	return nil
+}
+
+// Origin 区域名
+func (z *Zone) Origin() string {
+	return z.origin
+}
+
+// Answer 按权威服务器的方式应答 qname（RFC 1034 4.3.2），填充 resp 的各个区段
+func (z *Zone) Answer(resp *dns.Msg, qname string, qtype uint16) {
+	resp.Authoritative = true
+	name := dns.CanonicalName(qname)
+	for range maxChain {
+		// 名称路径上的委派与 DNAME
+		if ns := z.delegation(name, qtype); ns != nil {
+			resp.Authoritative = len(resp.Answer) > 0
+			resp.Ns = append(resp.Ns, copyRRs(ns)...)
+			resp.Extra = append(resp.Extra, z.glue(ns)...)
+			return
+		}
+		if target, ok := z.dname(resp, name); ok {
+			if !dns.IsSubDomain(z.origin, target) {
+				return
+			}
+			name = target
+			continue
+		}
+
+		types, ok := z.records[name]
+		owner := name
+		if !ok {
+			if _, exists := z.names[name]; exists {
+				// 空非终端
+				z.nodata(resp)
+				return
+			}
+			// 通配符（RFC 4592）
+			wildcard := "*." + z.closestEncloser(name)
+			if types, ok = z.records[wildcard]; !ok {
+				resp.Rcode = dns.RcodeNameError
+				z.nodata(resp)
+				return
+			}
+			owner = wildcard
+		}
+
+		if qtype == dns.TypeANY {
+			for _, rrs := range types {
+				resp.Answer = append(resp.Answer, synthesize(rrs, owner, name)...)
+			}
+			return
+		}
+		if rrs := types[qtype]; len(rrs) > 0 {
+			resp.Answer = append(resp.Answer, synthesize(rrs, owner, name)...)
+			z.additional(resp, rrs)
+			return
+		}
+		cname := types[dns.TypeCNAME]
+		if len(cname) == 0 {
+			z.nodata(resp)
+			return
+		}
+		resp.Answer = append(resp.Answer, synthesize(cname, owner, name)...)
+		target := dns.CanonicalName(cname[0].(*dns.CNAME).Target)
+		if !dns.IsSubDomain(z.origin, target) {
+			return
+		}
+		name = target
+	}
+}
+
+// delegation 名称位于区域分割点及其以下时返回委派的 NS 记录，DS 查询由分割点的父区域应答
+func (z *Zone) delegation(name string, qtype uint16) []dns.RR {
+	labels := dns.SplitDomainName(name)
+	for i := dns.CountLabel(z.origin) + 1; i <= len(labels); i++ {
+		cut := dns.Fqdn(strings.Join(labels[len(labels)-i:], "."))
+		if cut == name && qtype == dns.TypeDS {
+			return nil
+		}
+		if ns := z.records[cut][dns.TypeNS]; len(ns) > 0 {
+			return ns
+		}
+	}
+	return nil
+}
+
+// dname name 位于 DNAME 之下时添加 DNAME 与合成的 CNAME（RFC 6672），返回新的目标
+func (z *Zone) dname(resp *dns.Msg, name string) (string, bool) {
+	labels := dns.SplitDomainName(name)
+	for i := dns.CountLabel(z.origin); i < len(labels); i++ {
+		owner := dns.Fqdn(strings.Join(labels[len(labels)-i:], "."))
+		rrs := z.records[owner][dns.TypeDNAME]
+		if len(rrs) == 0 {
+			continue
+		}
+		d := rrs[0].(*dns.DNAME)
+		target := strings.TrimSuffix(name, owner) + dns.CanonicalName(d.Target)
+		if _, ok := dns.IsDomainName(target); !ok || len(target) > 255 {
+			resp.Rcode = dns.RcodeYXDomain
+			resp.Answer = append(resp.Answer, dns.Copy(d))
+			return "", true
+		}
+		resp.Answer = append(resp.Answer, dns.Copy(d), &dns.CNAME{
+			Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: d.Hdr.Ttl},
+			Target: target,
+		})
+		return target, true
+	}
+	return "", false
+}
+
+// closestEncloser name 最近的存在的上级名称
+func (z *Zone) closestEncloser(name string) string {
+	for name != z.origin {
+		name = parent(name)
+		if _, ok := z.names[name]; ok {
+			return name
+		}
+	}
+	return z.origin
+}
+
+// nodata 否定应答在授权区携带 SOA，TTL 取 SOA TTL 与 MINIMUM 的较小值（RFC 2308）
+func (z *Zone) nodata(resp *dns.Msg) {
+	soa := dns.Copy(z.soa).(*dns.SOA)
+	soa.Hdr.Ttl = min(soa.Hdr.Ttl, soa.Minttl)
+	resp.Ns = append(resp.Ns, soa)
+}
+
+// glue 区域内 NS 主机名的地址
+func (z *Zone) glue(ns []dns.RR) []dns.RR {
+	var extra []dns.RR
+	for _, rr := range ns {
+		host := dns.CanonicalName(rr.(*dns.NS).Ns)
+		extra = append(extra, copyRRs(z.records[host][dns.TypeA])...)
+		extra = append(extra, copyRRs(z.records[host][dns.TypeAAAA])...)
+	}
+	return extra
+}
+
+// additional 附加区携带 MX、SRV、NS 目标在区域内的地址
+func (z *Zone) additional(resp *dns.Msg, rrs []dns.RR) {
+	for _, rr := range rrs {
+		var host string
+		switch rr := rr.(type) {
+		case *dns.MX:
+			host = rr.Mx
+		case *dns.SRV:
+			host = rr.Target
+		case *dns.NS:
+			host = rr.Ns
+		default:
+			continue
+		}
+		host = dns.CanonicalName(host)
+		if !dns.IsSubDomain(z.origin, host) || z.delegation(host, dns.TypeA) != nil {
+			continue
+		}
+		resp.Extra = append(resp.Extra, copyRRs(z.records[host][dns.TypeA])...)
+		resp.Extra = append(resp.Extra, copyRRs(z.records[host][dns.TypeAAAA])...)
+	}
+}
+
+// synthesize 复制记录，通配符展开时以查询名替换记录的所有者
+func synthesize(rrs []dns.RR, owner, name string) []dns.RR {
+	out := copyRRs(rrs)
+	if owner != name {
+		for _, rr := range out {
+			rr.Header().Name = name
+		}
+	}
+	return out
+}
+
+// copyRRs 复制记录，避免应答修改区域数据
+func copyRRs(rrs []dns.RR) []dns.RR {
+	out := make([]dns.RR, len(rrs))
+	for i, rr := range rrs {
+		out[i] = dns.Copy(rr)
+	}
+	return out
+}
+
+func parent(name string) string {
+	if i, end := dns.NextLabel(name, 0); !end {
+		return name[i:]
+	}
+	return "."
+}
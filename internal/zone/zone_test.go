@@ -0,0 +1,186 @@
+package zone
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+var testZone = `$ORIGIN example.
+$TTL 3600
+@          IN SOA   ns.example. hostmaster.example. 1 7200 3600 1209600 300
+@          IN NS    ns.example.
+ns         IN A     192.0.2.53
+www        IN A     192.0.2.1
+*.wild     IN A     192.0.2.2
+sub.wild   IN TXT   "exists"
+a.ent      IN A     192.0.2.3
+sub        IN NS    ns.sub.example.
+ns.sub     IN A     192.0.2.54
+sub        IN DS    12345 13 2 0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef
+c1         IN CNAME c2.example.
+c2         IN CNAME www.example.
+ext        IN CNAME www.other.net.
+dn         IN DNAME target.example.
+www.target IN A     192.0.2.4
+long       IN DNAME ` + longLabel + `.` + longLabel + `.` + longLabel + `.example.
+mail       IN MX    10 www.example.
+`
+
+var longLabel = strings.Repeat("a", 63)
+
+func parseTestZone(t *testing.T) *Zone {
+	t.Helper()
+	z, err := Parse(strings.NewReader(testZone), "example.", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return z
+}
+
+// rrs 区段中的记录，每条记录格式为 "名称 类型 数据"
+func rrs(section []dns.RR) []string {
+	out := make([]string, 0, len(section))
+	for _, rr := range section {
+		fields := strings.Fields(rr.String())
+		out = append(out, fields[0]+" "+fields[3]+" "+strings.Join(fields[4:], " "))
+	}
+	return out
+}
+
+func TestAnswer(t *testing.T) {
+	z := parseTestZone(t)
+	tests := []struct {
+		name    string
+		qname   string
+		qtype   uint16
+		rcode   int
+		aa      bool
+		answer  []string
+		ns      []string
+		extra   []string
+		soaOnly bool
+	}{
+		{name: "exact", qname: "www.example.", qtype: dns.TypeA, aa: true,
+			answer: []string{"www.example. A 192.0.2.1"}},
+		{name: "nodata", qname: "www.example.", qtype: dns.TypeAAAA, aa: true, soaOnly: true},
+		{name: "nxdomain", qname: "nope.example.", qtype: dns.TypeA, rcode: dns.RcodeNameError, aa: true, soaOnly: true},
+		{name: "wildcard", qname: "foo.wild.example.", qtype: dns.TypeA, aa: true,
+			answer: []string{"foo.wild.example. A 192.0.2.2"}},
+		{name: "wildcard multiple labels", qname: "a.b.wild.example.", qtype: dns.TypeA, aa: true,
+			answer: []string{"a.b.wild.example. A 192.0.2.2"}},
+		{name: "wildcard nodata", qname: "foo.wild.example.", qtype: dns.TypeAAAA, aa: true, soaOnly: true},
+		{name: "existing name blocks wildcard", qname: "sub.wild.example.", qtype: dns.TypeA, aa: true, soaOnly: true},
+		{name: "wildcard owner itself", qname: "*.wild.example.", qtype: dns.TypeA, aa: true,
+			answer: []string{"*.wild.example. A 192.0.2.2"}},
+		{name: "empty non-terminal", qname: "ent.example.", qtype: dns.TypeA, aa: true, soaOnly: true},
+		{name: "below empty non-terminal", qname: "x.ent.example.", qtype: dns.TypeA, rcode: dns.RcodeNameError, aa: true, soaOnly: true},
+		{name: "referral with glue", qname: "www.sub.example.", qtype: dns.TypeA,
+			ns: []string{"sub.example. NS ns.sub.example."}, extra: []string{"ns.sub.example. A 192.0.2.54"}},
+		{name: "referral at cut", qname: "sub.example.", qtype: dns.TypeA,
+			ns: []string{"sub.example. NS ns.sub.example."}, extra: []string{"ns.sub.example. A 192.0.2.54"}},
+		{name: "ds at cut", qname: "sub.example.", qtype: dns.TypeDS, aa: true,
+			answer: []string{"sub.example. DS 12345 13 2 0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF"}},
+		{name: "ds below cut", qname: "x.sub.example.", qtype: dns.TypeDS,
+			ns: []string{"sub.example. NS ns.sub.example."}, extra: []string{"ns.sub.example. A 192.0.2.54"}},
+		{name: "cname chain", qname: "c1.example.", qtype: dns.TypeA, aa: true,
+			answer: []string{"c1.example. CNAME c2.example.", "c2.example. CNAME www.example.", "www.example. A 192.0.2.1"}},
+		{name: "cname query", qname: "c1.example.", qtype: dns.TypeCNAME, aa: true,
+			answer: []string{"c1.example. CNAME c2.example."}},
+		{name: "cname out of zone", qname: "ext.example.", qtype: dns.TypeA, aa: true,
+			answer: []string{"ext.example. CNAME www.other.net."}},
+		{name: "dname", qname: "www.dn.example.", qtype: dns.TypeA, aa: true,
+			answer: []string{"dn.example. DNAME target.example.", "www.dn.example. CNAME www.target.example.", "www.target.example. A 192.0.2.4"}},
+		{name: "dname owner", qname: "dn.example.", qtype: dns.TypeDNAME, aa: true,
+			answer: []string{"dn.example. DNAME target.example."}},
+		{name: "dname target nxdomain", qname: "nope.dn.example.", qtype: dns.TypeA, rcode: dns.RcodeNameError, aa: true,
+			answer: []string{"dn.example. DNAME target.example.", "nope.dn.example. CNAME nope.target.example."}, soaOnly: true},
+		{name: "dname yxdomain", qname: longLabel + ".long.example.", qtype: dns.TypeA, rcode: dns.RcodeYXDomain, aa: true,
+			answer: []string{"long.example. DNAME " + longLabel + "." + longLabel + "." + longLabel + ".example."}},
+		{name: "mx additional", qname: "mail.example.", qtype: dns.TypeMX, aa: true,
+			answer: []string{"mail.example. MX 10 www.example."}, extra: []string{"www.example. A 192.0.2.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := new(dns.Msg)
+			z.Answer(resp, tt.qname, tt.qtype)
+			if resp.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if resp.Authoritative != tt.aa {
+				t.Errorf("aa = %v, want %v", resp.Authoritative, tt.aa)
+			}
+			if got := strings.Join(rrs(resp.Answer), "\n"); got != strings.Join(tt.answer, "\n") {
+				t.Errorf("answer:\n%s\nwant:\n%s", got, strings.Join(tt.answer, "\n"))
+			}
+			if got := strings.Join(rrs(resp.Extra), "\n"); got != strings.Join(tt.extra, "\n") {
+				t.Errorf("extra:\n%s\nwant:\n%s", got, strings.Join(tt.extra, "\n"))
+			}
+			if tt.soaOnly {
+				// 否定应答的 SOA TTL 为 SOA TTL 与 MINIMUM 中较小者
+				if len(resp.Ns) != 1 || resp.Ns[0].Header().Rrtype != dns.TypeSOA || resp.Ns[0].Header().Ttl != 300 {
+					t.Errorf("authority = %v, want SOA with TTL 300", resp.Ns)
+				}
+				return
+			}
+			if got := strings.Join(rrs(resp.Ns), "\n"); got != strings.Join(tt.ns, "\n") {
+				t.Errorf("authority:\n%s\nwant:\n%s", got, strings.Join(tt.ns, "\n"))
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{name: "missing soa", text: "www IN A 192.0.2.1\n"},
+		{name: "out of zone", text: "@ IN SOA ns hm 1 1 1 1 1\nwww.other.net. IN A 192.0.2.1\n"},
+		{name: "cname and other data", text: "@ IN SOA ns hm 1 1 1 1 1\nwww IN CNAME a\nwww IN A 192.0.2.1\n"},
+		{name: "soa below apex", text: "@ IN SOA ns hm 1 1 1 1 1\nwww IN SOA ns hm 1 1 1 1 1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(tt.text), "example.", "test"); err == nil {
+				t.Fatal("want error")
+			}
+		})
+	}
+}
+
+// 区域顶点的 DS 由父区域应答，父区域不在本地时不应答
+func TestLookupApexDS(t *testing.T) {
+	parentZone := parseTestZone(t)
+	child, err := Parse(strings.NewReader("@ IN SOA ns hm 1 1 1 1 1\n@ IN NS ns.example.\nwww IN A 192.0.2.5\n"), "sub.example.", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := func(zs *Zones, name string, qtype uint16) (*dns.Msg, bool) {
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		return zs.Lookup(req)
+	}
+
+	zs := &Zones{zones: map[string]*Zone{"example.": parentZone, "sub.example.": child}}
+	resp, ok := lookup(zs, "sub.example.", dns.TypeDS)
+	if !ok || len(resp.Answer) != 1 || resp.Answer[0].Header().Rrtype != dns.TypeDS {
+		t.Fatalf("ds at child apex = %v, want parent's DS", resp)
+	}
+	resp, ok = lookup(zs, "www.sub.example.", dns.TypeA)
+	if !ok || len(resp.Answer) != 1 || !resp.Authoritative {
+		t.Fatalf("child lookup = %v, want child's answer", resp)
+	}
+	if resp, ok = lookup(zs, "example.", dns.TypeDS); ok {
+		t.Fatalf("ds at apex without local parent = %v, want no answer", resp)
+	}
+
+	zs = &Zones{zones: map[string]*Zone{"sub.example.": child}}
+	if resp, ok = lookup(zs, "sub.example.", dns.TypeDS); ok {
+		t.Fatalf("ds at apex without local parent = %v, want no answer", resp)
+	}
+	if _, ok = lookup(zs, "sub.example.", dns.TypeNS); !ok {
+		t.Fatal("ns at apex not answered")
+	}
+}
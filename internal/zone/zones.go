@@ -0,0 +1,107 @@
+package zone
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// 本地区域配置
+type Options struct {
+	// 区域名，如 home.lan
+	Name string `yaml:"name"`
+	// 区域文件（RFC 1035 格式）
+	File string `yaml:"file"`
+}
+
+// Info 区域信息
+type Info struct {
+	Name    string `json:"name"`
+	File    string `json:"file"`
+	Serial  uint32 `json:"serial"`
+	Records int    `json:"records"`
+}
+
+// Zones 本地权威区域，查询名位于区域内时直接应答，不再查询上游
+type Zones struct {
+	// 区域名 -> 区域
+	zones map[string]*Zone
+}
+
+func New(opts []Options) (*Zones, error) {
+	zs := &Zones{zones: make(map[string]*Zone)}
+	for _, opt := range opts {
+		if opt.Name == "" || opt.File == "" {
+			return nil, fmt.Errorf("zone requires name and file")
+		}
+		z, err := Load(opt.Name, opt.File)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := zs.zones[z.origin]; ok {
+			return nil, fmt.Errorf("duplicate zone %s", z.origin)
+		}
+		zs.zones[z.origin] = z
+		slog.Info("zone loaded", "zone", z.origin, "file", opt.File, "serial", z.soa.Serial, "records", z.count)
+	}
+	return zs, nil
+}
+
+// find 查找包含 name 的最近区域
+func (zs *Zones) find(name string) *Zone {
+	if zs == nil || len(zs.zones) == 0 {
+		return nil
+	}
+	name = dns.CanonicalName(name)
+	for {
+		if z, ok := zs.zones[name]; ok {
+			return z
+		}
+		if name == "." {
+			return nil
+		}
+		name = parent(name)
+	}
+}
+
+// Contains name 是否位于本地区域内
+func (zs *Zones) Contains(name string) bool {
+	return zs.find(name) != nil
+}
+
+// Lookup 查询名位于本地区域内时返回权威应答；区域顶点的 DS 属于父区域（RFC 4035 3.1.4.1），
+// 父区域不在本地时不应答，由上游解析
+func (zs *Zones) Lookup(req *dns.Msg) (*dns.Msg, bool) {
+	q := req.Question[0]
+	if q.Qclass != dns.ClassINET {
+		return nil, false
+	}
+	z := zs.find(q.Name)
+	if z != nil && q.Qtype == dns.TypeDS && z.origin == dns.CanonicalName(q.Name) && z.origin != "." {
+		z = zs.find(parent(z.origin))
+	}
+	if z == nil {
+		return nil, false
+	}
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.RecursionAvailable = true
+	z.Answer(resp, q.Name, q.Qtype)
+	return resp, true
+}
+
+// List 已加载的区域
+func (zs *Zones) List() []Info {
+	infos := make([]Info, 0)
+	if zs == nil {
+		return infos
+	}
+	for _, z := range zs.zones {
+		infos = append(infos, Info{Name: z.origin, File: z.file, Serial: z.soa.Serial, Records: z.count})
+	}
+	slices.SortFunc(infos, func(a, b Info) int { return strings.Compare(a.Name, b.Name) })
+	return infos
+}
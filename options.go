@@ -3,12 +3,19 @@ package godns
 import (
 	"log/slog"
 
+	"github.com/taodev/godns/internal/admin"
 	"github.com/taodev/godns/internal/cache"
+	"github.com/taodev/godns/internal/filter"
+	"github.com/taodev/godns/internal/querylog"
 	"github.com/taodev/godns/internal/rewrite"
 	"github.com/taodev/godns/internal/route"
+	"github.com/taodev/godns/internal/transport"
+	"github.com/taodev/godns/internal/transport/group"
 	"github.com/taodev/godns/internal/transport/http"
+	"github.com/taodev/godns/internal/transport/quic"
 	"github.com/taodev/godns/internal/transport/tcp"
 	"github.com/taodev/godns/internal/transport/udp"
+	"github.com/taodev/godns/internal/zone"
 	"github.com/taodev/pkg/defaults"
 )
 
@@ -18,6 +25,8 @@ type Options struct {
 	LogLevel string `yaml:"log-level" default:"info"`
 	// Debug pprof
 	Pprof string `yaml:"pprof"`
+	// 管理接口配置
+	Admin admin.Options `yaml:"admin"`
 	// 入站配置
 	Inbounds struct {
 		// UDP 入站配置
@@ -32,10 +41,14 @@ type Options struct {
 		HTTP *http.Options `yaml:"http"`
 		// HTTPS 入站配置
 		HTTPS *http.Options `yaml:"https"`
+		// DoQ 入站配置
+		QUIC *quic.Options `yaml:"quic"`
 	} `yaml:"inbound"`
 
 	// GeoSite 路径
 	GeoSite string `yaml:"geosite" default:"geosite.dat"`
+	// GeoIP 路径
+	GeoIP string `yaml:"geoip" default:"geoip.dat"`
 	// STCP 全局配置
 	StcpKey string `yaml:"stcp-key"`
 
@@ -43,12 +56,22 @@ type Options struct {
 	BootstrapDNS []string `yaml:"bootstrap-dns" default:"[223.5.5.5, 223.6.6.6]"`
 	// 缓存配置
 	Cache cache.Options `yaml:"cache"`
+	// 查询日志配置
+	QueryLog querylog.Options `yaml:"querylog"`
 	// 上游配置
 	Outbounds map[string]string `yaml:"outbound"`
+	// 上游组配置
+	OutboundGroups map[string]*group.Options `yaml:"outbound-group"`
+	// 上游健康检查配置
+	HealthCheck transport.HealthOptions `yaml:"health-check"`
 	// 路由配置
 	Route route.Options `yaml:"route"`
 	// 重写配置
 	Rewrite rewrite.Options `yaml:"rewrite"`
+	// 本地权威区域
+	Zones []zone.Options `yaml:"zones"`
+	// 拦截配置
+	Filter filter.Options `yaml:"filter"`
 }
 
 func (o *Options) Default() error {
@@ -0,0 +1,208 @@
+package godns
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/taodev/godns/internal/filter"
+	"github.com/taodev/godns/internal/rewrite"
+	"github.com/taodev/godns/internal/route"
+	"github.com/taodev/godns/internal/transport"
+	"github.com/taodev/godns/internal/zone"
+	"github.com/taodev/godns/pkg/bootstrap"
+	"github.com/taodev/pkg/geodb"
+)
+
+// 热重载后旧上游延迟关闭，等待进行中的请求完成
+const reloadGracePeriod = time.Minute
+
+// routerHolder 持有当前生效的路由，入站与缓存通过它访问路由，热重载时原子替换
+type routerHolder struct {
+	atomic.Pointer[route.Router]
+}
+
+func newRouterHolder(router *route.Router) *routerHolder {
+	h := new(routerHolder)
+	h.Store(router)
+	return h
+}
+
+func (h *routerHolder) Exchange(request *dns.Msg, inbound string, ip string) (*dns.Msg, error) {
+	return h.Load().Exchange(request, inbound, ip)
+}
+
+func (h *routerHolder) Resolve(in *dns.Msg, scope string, ip net.IP) (*dns.Msg, string, error) {
+	return h.Load().Resolve(in, scope, ip)
+}
+
+// SetLoader 设置配置加载函数，用于 ReloadConfig
+func (s *DnsServer) SetLoader(loader func() (*Options, error)) {
+	s.loader = loader
+}
+
+// ReloadConfig 重新加载配置文件并应用
+func (s *DnsServer) ReloadConfig() error {
+	if s.loader == nil {
+		return errors.New("config loader is not set")
+	}
+	opts, err := s.loader()
+	if err != nil {
+		return err
+	}
+	return s.Reload(opts)
+}
+
+// Reload 应用新配置：原子替换路由、重写、拦截与上游，只重启地址或证书变化的入站，保留缓存。
+// 校验失败时旧配置继续运行
+func (s *DnsServer) Reload(opts *Options) (err error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	if s.router == nil {
+		return errors.New("server not started")
+	}
+	select {
+	case <-s.closeCh:
+		return errors.New("server closed")
+	default:
+	}
+
+	s.access.RLock()
+	oldOpts := s.Options
+	s.access.RUnlock()
+
+	// 构建新组件，任一失败都不影响当前配置
+	if err = bootstrap.SetDNS(opts.BootstrapDNS); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			bootstrap.SetDNS(oldOpts.BootstrapDNS)
+			geodb.GeoSitePath = oldOpts.GeoSite
+			route.GeoIPPath = oldOpts.GeoIP
+		}
+	}()
+	geodb.GeoSitePath = opts.GeoSite
+	route.GeoIPPath = opts.GeoIP
+	outbound, err := transport.NewManager(opts.Outbounds, opts.OutboundGroups, &opts.HealthCheck, opts.StcpKey)
+	if err != nil {
+		return err
+	}
+	rewriter, err := rewrite.NewRewriter(opts.Rewrite)
+	if err != nil {
+		outbound.Close()
+		return fmt.Errorf("rewrite: %w", err)
+	}
+	newFilter, err := filter.New(opts.Filter)
+	if err != nil {
+		outbound.Close()
+		return fmt.Errorf("filter: %w", err)
+	}
+	zones, err := zone.New(opts.Zones)
+	if err != nil {
+		outbound.Close()
+		newFilter.Close()
+		return fmt.Errorf("zone: %w", err)
+	}
+	router, err := route.New(&opts.Route, outbound, rewriter, newFilter, zones, s.cache, s.querylog)
+	if err != nil {
+		outbound.Close()
+		newFilter.Close()
+		return fmt.Errorf("route: %w", err)
+	}
+	if err = newFilter.Start(); err != nil {
+		outbound.Close()
+		newFilter.Close()
+		return fmt.Errorf("filter: %w", err)
+	}
+	opts.prepareInbounds()
+	// 先启动变化的入站，失败时恢复原有入站，旧配置继续运行
+	commitInbounds, err := s.reloadInbounds(oldOpts, opts)
+	if err != nil {
+		outbound.Close()
+		newFilter.Close()
+		return err
+	}
+
+	// 替换
+	s.router.Store(router)
+	s.access.Lock()
+	oldOutbound := s.outbound
+	s.outbound = outbound
+	s.rewriter = rewriter
+	oldFilter := s.filter
+	s.filter = newFilter
+	s.Options = opts
+	s.access.Unlock()
+	s.level.Set(opts.LoggerLevel())
+	time.AfterFunc(reloadGracePeriod, oldOutbound.Close)
+	time.AfterFunc(reloadGracePeriod, oldFilter.Close)
+	commitInbounds()
+
+	if !reflect.DeepEqual(oldOpts.Cache, opts.Cache) {
+		slog.Warn("reload: cache options changed, restart required to apply")
+	}
+	if !reflect.DeepEqual(oldOpts.QueryLog, opts.QueryLog) {
+		slog.Warn("reload: querylog options changed, restart required to apply")
+	}
+	if !reflect.DeepEqual(oldOpts.Admin, opts.Admin) {
+		slog.Warn("reload: admin options changed, restart required to apply")
+	}
+	slog.Info("config reloaded")
+	return nil
+}
+
+// reloadInbounds 启动配置变化或新增的入站，任一入站启动失败时关闭已启动的入站、恢复原有入站并返回错误；
+// 成功时返回的函数在替换其他组件后关闭被替换或移除的旧入站
+func (s *DnsServer) reloadInbounds(oldOpts, opts *Options) (func(), error) {
+	olds := oldOpts.inboundOptions()
+	news := opts.inboundOptions()
+	started := make(map[string]inbound)
+	// 为释放地址已关闭的旧入站
+	stopped := make(map[string]bool)
+	for typ, newOpts := range news {
+		old, ok := s.inbounds[typ]
+		if ok && reflect.DeepEqual(olds[typ], newOpts) {
+			continue
+		}
+		in, err := s.newInbound(typ, newOpts)
+		if err != nil && ok && inboundAddr(olds[typ]) == inboundAddr(newOpts) {
+			// 旧入站仍占用相同的地址，关闭后重试
+			old.Close()
+			stopped[typ] = true
+			in, err = s.newInbound(typ, newOpts)
+		}
+		if err != nil {
+			for _, in := range started {
+				in.Close()
+			}
+			for typ := range stopped {
+				if err := s.startInbound(typ, olds[typ]); err != nil {
+					slog.Error("reload: restore inbound failed", "type", typ, "err", err)
+					delete(s.inbounds, typ)
+				}
+			}
+			return nil, err
+		}
+		started[typ] = in
+	}
+	return func() {
+		for typ, in := range s.inbounds {
+			if _, ok := news[typ]; ok && started[typ] == nil {
+				continue
+			}
+			slog.Info("reload: stop inbound", "type", typ)
+			if !stopped[typ] {
+				in.Close()
+			}
+			delete(s.inbounds, typ)
+		}
+		maps.Copy(s.inbounds, started)
+	}, nil
+}